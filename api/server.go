@@ -13,6 +13,7 @@ import (
 	"github.com/tphan267/arqut-edge-ce/pkg/api"
 	"github.com/tphan267/arqut-edge-ce/pkg/core"
 	"github.com/tphan267/arqut-edge-ce/pkg/haaddon"
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
 	"github.com/tphan267/arqut-edge-ce/pkg/providers"
 	"github.com/tphan267/arqut-edge-ce/pkg/providers/proxy"
 	"github.com/tphan267/arqut-edge-ce/ui"
@@ -65,6 +66,7 @@ func (s *ApiServer) setupRoutes() {
 	s.api.Post("/config/haaddon/expose", s.handleExposeHAAddon)
 
 	s.app.Get("/health", s.handleHealth)
+	s.app.Get("/metrics", s.authMiddleware, s.handlePrometheusMetrics)
 }
 
 func (s *ApiServer) setupUI() {
@@ -194,6 +196,16 @@ func (s *ApiServer) handleHealth(c *fiber.Ctx) error {
 	})
 }
 
+// handlePrometheusMetrics serves proxy, signaling and WireGuard metrics in
+// the Prometheus text format
+func (s *ApiServer) handlePrometheusMetrics(c *fiber.Ctx) error {
+	w := metrics.NewWriter()
+	s.providers.CollectMetrics(w)
+
+	c.Set(fiber.HeaderContentType, metrics.ContentType)
+	return c.SendString(w.String())
+}
+
 // handleGetNetworkSettings returns network subnets for HA addon configuration
 func (s *ApiServer) handleGetNetworkSettings(c *fiber.Ctx) error {
 	subnets, err := haaddon.GetNetworkSubnets()
@@ -3,6 +3,7 @@ module github.com/tphan267/arqut-edge-ce
 go 1.24.0
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/glebarez/sqlite v1.11.0
 	github.com/gofiber/fiber/v2 v2.52.9
 	github.com/golobby/config/v3 v3.4.2
@@ -19,7 +20,6 @@ require (
 
 require (
 	github.com/BurntSushi/toml v1.2.1 // indirect
-	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/glebarez/go-sqlite v1.22.0 // indirect
 	github.com/golobby/cast v1.3.3 // indirect
@@ -25,6 +25,20 @@ type Config struct {
 	ServerAddr string `yaml:"server_addr"`
 	LogLevel   string `yaml:"log_level"`
 
+	// Shared virtual host listeners routing by Host header or SNI (0 disables)
+	VHostHTTPPort  int `yaml:"vhost_http_port"`
+	VHostHTTPSPort int `yaml:"vhost_https_port"`
+
+	// Tunnel ports handed out to new services, and ports inside the range
+	// that are only used when requested explicitly ("8080,8500-8510")
+	TunnelPortStart     int    `yaml:"tunnel_port_start"`
+	TunnelPortEnd       int    `yaml:"tunnel_port_end"`
+	ReservedTunnelPorts string `yaml:"reserved_tunnel_ports"`
+
+	// Access log rotation (0 uses the defaults)
+	AccessLogMaxRows    int `yaml:"access_log_max_rows"`
+	AccessLogMaxAgeDays int `yaml:"access_log_max_age_days"`
+
 	Version   string `yaml:"-"`
 	IsHAAddon bool   `yaml:"-"` // Flag indicating if running as Home Assistant Add-on
 
@@ -107,6 +121,12 @@ func (c *Config) EnsureDefaultConfig(save bool) error {
 		changed = true
 	}
 
+	if c.TunnelPortStart == 0 && c.TunnelPortEnd == 0 {
+		c.TunnelPortStart = 8000
+		c.TunnelPortEnd = 9000
+		changed = true
+	}
+
 	c.mu.Unlock()
 
 	if changed && save {
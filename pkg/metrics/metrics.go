@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ContentType is the Prometheus text exposition format served on /metrics
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// Collector is implemented by components that expose metrics
+type Collector interface {
+	// CollectMetrics writes the component's current metrics
+	CollectMetrics(w *Writer)
+}
+
+// Label is a metric label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// L is shorthand for building a Label
+func L(name, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+// Writer renders metrics in the Prometheus text format. All samples of a
+// metric must be written right after its Family call.
+type Writer struct {
+	b strings.Builder
+}
+
+// NewWriter creates an empty writer
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Family starts a metric with its help text and type
+func (w *Writer) Family(name, help, metricType string) {
+	w.b.WriteString("# HELP ")
+	w.b.WriteString(name)
+	w.b.WriteByte(' ')
+	w.b.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	w.b.WriteString("\n# TYPE ")
+	w.b.WriteString(name)
+	w.b.WriteByte(' ')
+	w.b.WriteString(metricType)
+	w.b.WriteByte('\n')
+}
+
+// Sample writes one value of the current metric
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			w.b.WriteString(label.Name)
+			w.b.WriteString(`="`)
+			w.b.WriteString(labelEscaper.Replace(label.Value))
+			w.b.WriteByte('"')
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(formatValue(value))
+	w.b.WriteByte('\n')
+}
+
+// Histogram writes the buckets, sum and count of a histogram
+func (w *Writer) Histogram(name string, h *Histogram, labels ...Label) {
+	counts, sum, count := h.snapshot()
+
+	bucketLabels := append(append([]Label{}, labels...), Label{Name: "le"})
+	le := &bucketLabels[len(bucketLabels)-1]
+
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += counts[i]
+		le.Value = formatValue(upper)
+		w.Sample(name+"_bucket", float64(cumulative), bucketLabels...)
+	}
+	le.Value = "+Inf"
+	w.Sample(name+"_bucket", float64(count), bucketLabels...)
+
+	w.Sample(name+"_sum", sum, labels...)
+	w.Sample(name+"_count", float64(count), labels...)
+}
+
+// String returns everything written so far
+func (w *Writer) String() string {
+	return w.b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue renders a sample value the way Prometheus expects
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds used for request
+// latency histograms
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into fixed buckets. It is safe for concurrent
+// use.
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		buckets: sorted,
+		counts:  make([]atomic.Uint64, len(sorted)),
+	}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i].Add(1)
+	}
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	h.count.Add(1)
+}
+
+// snapshot returns the per-bucket counts, sum and total count
+func (h *Histogram) snapshot() ([]uint64, float64, uint64) {
+	counts := make([]uint64, len(h.counts))
+	var total uint64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	// Observations above the last bucket only show up in the total
+	count := max(h.count.Load(), total)
+	return counts, math.Float64frombits(h.sumBits.Load()), count
+}
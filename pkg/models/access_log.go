@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// AccessLogEntry is one proxied HTTP request
+type AccessLogEntry struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ServiceID string    `json:"service_id" gorm:"type:varchar(8);index:idx_access_log_service_time"`
+	Time      time.Time `json:"time" gorm:"index:idx_access_log_service_time"`
+	ClientIP  string    `json:"client_ip" gorm:"type:varchar(45)"`
+	Method    string    `json:"method" gorm:"type:varchar(16)"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	BytesIn   int64     `json:"bytes_in"`
+	BytesOut  int64     `json:"bytes_out"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// TableName overrides the table name
+func (AccessLogEntry) TableName() string {
+	return "access_log"
+}
+
+// AccessLogFilter selects access log entries of a service
+type AccessLogFilter struct {
+	ServiceID  string
+	From       time.Time // inclusive, zero for no lower bound
+	To         time.Time // exclusive, zero for no upper bound
+	StatusMin  int       // inclusive, 0 for any
+	StatusMax  int       // inclusive, 0 for any
+	PathPrefix string
+	Limit      int
+}
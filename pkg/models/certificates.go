@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// CertificateNameCA is the name under which the edge certificate authority is stored
+const CertificateNameCA = "ca"
+
+// Certificate is a PEM encoded certificate and private key managed by the edge
+type Certificate struct {
+	Name      string    `json:"name" gorm:"type:varchar(255);primaryKey"` // "ca" or the host the certificate was issued for
+	CertPEM   string    `json:"cert_pem"`
+	KeyPEM    string    `json:"-"`
+	NotAfter  time.Time `json:"not_after"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName overrides the table name
+func (Certificate) TableName() string {
+	return "certificates"
+}
@@ -2,17 +2,216 @@ package models
 
 import "time"
 
+// Supported proxy service protocols
+const (
+	ProtocolHTTP      = "http"
+	ProtocolWebSocket = "websocket"
+	ProtocolTCP       = "tcp"
+	ProtocolUDP       = "udp"
+)
+
+// Upstream TLS modes for HTTP services
+const (
+	UpstreamTLSNone        = ""            // plain HTTP to the upstream
+	UpstreamTLSSystem      = "system"      // HTTPS verified against the system trust store
+	UpstreamTLSCustomCA    = "custom_ca"   // HTTPS verified against UpstreamCA
+	UpstreamTLSFingerprint = "fingerprint" // HTTPS pinned to the leaf certificate's SHA-256 fingerprint
+	UpstreamTLSInsecure    = "insecure"    // HTTPS without certificate verification
+)
+
+// Load balancing policies for services with several upstream targets
+const (
+	LoadBalanceRoundRobin = "round_robin" // default
+	LoadBalanceLeastConn  = "least_conn"
+	LoadBalanceWeighted   = "weighted"
+)
+
+// Active health check types
+const (
+	HealthCheckNone = ""     // no active probing
+	HealthCheckHTTP = "http" // GET HealthCheckPath on every upstream target
+	HealthCheckTCP  = "tcp"  // open a TCP connection to every upstream target
+)
+
+// Access gate modes for HTTP services
+const (
+	AuthModeNone     = ""         // no gate
+	AuthModeBasic    = "basic"    // HTTP basic auth with AuthUsername and the secret as password
+	AuthModeBearer   = "bearer"   // static bearer token
+	AuthModePassword = "password" // built-in login page setting a signed session cookie
+)
+
+// Access rule actions
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+)
+
+// Header rule directions and actions
+const (
+	HeaderRequest  = "request"  // headers sent to the upstream
+	HeaderResponse = "response" // headers returned to the client
+
+	HeaderAdd    = "add"    // append a value, keeping existing ones
+	HeaderSet    = "set"    // replace all values
+	HeaderRemove = "remove" // delete the header
+)
+
+// Timeout profiles for HTTP services. An empty profile picks streaming for
+// websocket services and standard otherwise.
+const (
+	TimeoutProfileStandard  = "standard"  // bounded read and write times
+	TimeoutProfileStreaming = "streaming" // no read or write deadline, responses flushed immediately
+)
+
+// Response cache size limits in megabytes
+const (
+	DefaultCacheSizeMB = 100
+	MaxCacheSizeMB     = 10240
+)
+
+// Maintenance mode Retry-After limits in seconds
+const (
+	DefaultMaintenanceRetryAfter = 300
+	MaxMaintenanceRetryAfter     = 86400
+)
+
+// MaxErrorPageSize is the largest custom error page template in bytes
+const MaxErrorPageSize = 64 << 10
+
+// Actions taken when a temporary service expires
+const (
+	ExpireDisable = "disable" // default
+	ExpireDelete  = "delete"
+)
+
+// Retry and circuit breaker limits
+const (
+	MaxRetryAttempts   = 5
+	MaxBreakerCooldown = 3600 // seconds
+)
+
+// Request inspector buffer sizes
+const (
+	DefaultCaptureSize = 50
+	MaxCaptureSize     = 500
+)
+
 // ProxyService represents a proxy service configuration
 type ProxyService struct {
-	ID         string    `json:"id" gorm:"type:varchar(8);primaryKey"`
-	Name       string    `json:"name" gorm:"type:varchar(128)"`
-	TunnelPort int       `json:"tunnel_port"`
-	LocalHost  string    `json:"local_host"`
-	LocalPort  int       `json:"local_port"`
-	Protocol   string    `json:"protocol" gorm:"type:varchar(10)"` // "http" or "websocket"
-	Enabled    bool      `json:"enabled"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         string `json:"id" gorm:"type:varchar(8);primaryKey"`
+	Name       string `json:"name" gorm:"type:varchar(128)"`
+	TunnelPort int    `json:"tunnel_port"`
+	LocalHost  string `json:"local_host"`
+	LocalPort  int    `json:"local_port"`
+	Protocol   string `json:"protocol" gorm:"type:varchar(10)"` // "http", "websocket", "tcp" or "udp"
+	Enabled    bool   `json:"enabled"`
+	Hostname   string `json:"hostname,omitempty" gorm:"type:varchar(253);index"` // virtual host name on the shared listeners
+
+	// Upstream TLS (HTTP and websocket services only)
+	UpstreamTLS         string `json:"upstream_tls" gorm:"type:varchar(16)"`
+	UpstreamCA          string `json:"upstream_ca,omitempty"`          // PEM encoded CA bundle for custom_ca
+	UpstreamFingerprint string `json:"upstream_fingerprint,omitempty"` // hex SHA-256 of the leaf certificate
+	UpstreamSNI         string `json:"upstream_sni,omitempty"`         // overrides the TLS server name
+
+	// TLS termination on the tunnel listener with the edge CA (HTTP and websocket services only)
+	TLSEnabled bool `json:"tls_enabled"`
+
+	// Path routes to other upstreams (HTTP and websocket services only)
+	Routes []ProxyServiceRoute `json:"routes,omitempty" gorm:"foreignKey:ServiceID"`
+
+	// Upstream targets balanced by LoadBalancing. When empty, LocalHost and
+	// LocalPort are the only target.
+	Upstreams     []ProxyServiceUpstream `json:"upstreams,omitempty" gorm:"foreignKey:ServiceID"`
+	LoadBalancing string                 `json:"load_balancing,omitempty" gorm:"type:varchar(16)"`
+
+	// Active health checks of the upstream targets, including those of path
+	// routes. Zero values use the defaults.
+	HealthCheck         string `json:"health_check" gorm:"type:varchar(8)"`
+	HealthCheckPath     string `json:"health_check_path,omitempty"`     // HTTP checks only
+	HealthCheckInterval int    `json:"health_check_interval,omitempty"` // seconds
+	HealthCheckTimeout  int    `json:"health_check_timeout,omitempty"`  // seconds
+	HealthCheckStatus   int    `json:"health_check_status,omitempty"`   // expected HTTP status, 0 accepts any 2xx or 3xx
+	HealthyThreshold    int    `json:"healthy_threshold,omitempty"`     // consecutive successes to mark a target healthy
+	UnhealthyThreshold  int    `json:"unhealthy_threshold,omitempty"`   // consecutive failures to mark a target unhealthy
+
+	// Access gate in front of the upstream (HTTP and websocket services only)
+	AuthMode       string `json:"auth_mode" gorm:"type:varchar(16)"`
+	AuthUsername   string `json:"auth_username,omitempty"`
+	AuthSecretHash string `json:"-"` // bcrypt hash of the password or bearer token
+
+	// Client IP rules evaluated on the tunnel listener
+	AccessRules []ProxyServiceAccessRule `json:"access_rules,omitempty" gorm:"foreignKey:ServiceID"`
+
+	// Header rewrites applied in order (HTTP and websocket services only)
+	HeaderRules []ProxyServiceHeaderRule `json:"header_rules,omitempty" gorm:"foreignKey:ServiceID"`
+
+	// Timeouts in seconds overriding the profile (HTTP and websocket services
+	// only). Zero keeps the profile value, -1 disables the timeout.
+	TimeoutProfile        string `json:"timeout_profile,omitempty" gorm:"type:varchar(16)"`
+	ReadHeaderTimeout     int    `json:"read_header_timeout,omitempty"`
+	IdleTimeout           int    `json:"idle_timeout,omitempty"`
+	WriteTimeout          int    `json:"write_timeout,omitempty"`
+	UpstreamHeaderTimeout int    `json:"upstream_header_timeout,omitempty"`
+	FlushInterval         int    `json:"flush_interval,omitempty"` // milliseconds, -1 flushes after every write
+
+	// Per-client limits. Zero disables a limit.
+	RateLimit int `json:"rate_limit,omitempty"` // requests, new connections or new UDP sessions per second
+	RateBurst int `json:"rate_burst,omitempty"` // bucket size, at least RateLimit
+	ConnLimit int `json:"conn_limit,omitempty"` // concurrent requests, connections or UDP sessions
+
+	// Circuit breaker and retries on upstream connection failures (HTTP and
+	// websocket services only)
+	BreakerThreshold int `json:"breaker_threshold,omitempty"` // consecutive failed requests opening the breaker, 0 disables it
+	BreakerCooldown  int `json:"breaker_cooldown,omitempty"`  // seconds before a trial request, 0 uses the default
+	RetryAttempts    int `json:"retry_attempts,omitempty"`    // extra attempts for failed GET and HEAD requests
+
+	// Shadow upstream receiving an asynchronous copy of a share of the
+	// requests to the main upstream, with its responses discarded (HTTP
+	// services only). Shares the upstream TLS settings.
+	ShadowHost    string `json:"shadow_host,omitempty"`
+	ShadowPort    int    `json:"shadow_port,omitempty"`
+	ShadowPercent int    `json:"shadow_percent,omitempty"` // share of requests mirrored, 0 disables mirroring
+
+	// Request inspector keeping the last requests in memory (HTTP and
+	// websocket services only)
+	CaptureEnabled bool `json:"capture_enabled"`
+	CaptureSize    int  `json:"capture_size,omitempty"` // requests kept, 0 uses the default
+
+	// Response compression negotiated by Accept-Encoding (HTTP and websocket
+	// services only)
+	Compression        bool   `json:"compression"`
+	CompressionTypes   string `json:"compression_types,omitempty"`    // comma-separated MIME types, "text/*" matches a whole type; empty uses the defaults
+	CompressionMinSize int    `json:"compression_min_size,omitempty"` // bytes, 0 uses the default
+
+	// Disk cache of upstream responses following Cache-Control and ETag
+	// (HTTP and websocket services only)
+	CacheEnabled bool `json:"cache_enabled"`
+	CacheSizeMB  int  `json:"cache_size_mb,omitempty"` // 0 uses the default
+
+	// Maintenance mode serves the 503 page instead of proxying, and custom
+	// pages replace the built-in 502, 503 and 504 responses (HTTP and
+	// websocket services only)
+	Maintenance           bool                    `json:"maintenance"`
+	MaintenanceRetryAfter int                     `json:"maintenance_retry_after,omitempty"` // seconds, 0 uses the default
+	ErrorPages            []ProxyServiceErrorPage `json:"error_pages,omitempty" gorm:"foreignKey:ServiceID"`
+
+	// Availability windows. When a service has any, it is enabled inside them
+	// and disabled outside them by the scheduler.
+	Schedules        []ProxyServiceSchedule `json:"schedules,omitempty" gorm:"foreignKey:ServiceID"`
+	ScheduleTimezone string                 `json:"schedule_timezone,omitempty" gorm:"type:varchar(64)"` // IANA name, empty uses the device timezone
+
+	// Lifetime of a temporary service. Once past ExpiresAt or MaxUses, the
+	// service is disabled or deleted according to ExpireAction. A use is one
+	// client connection: an HTTP connection once a request on it gets past
+	// the access gate and maintenance page, a TCP connection or a UDP session.
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" gorm:"index"`
+	MaxUses      int        `json:"max_uses,omitempty"` // client connections, 0 for no limit
+	Uses         int        `json:"uses"`
+	ExpireAction string     `json:"expire_action,omitempty" gorm:"type:varchar(8)"` // "disable" or "delete"
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // TableName overrides the table name
@@ -20,10 +219,177 @@ func (ProxyService) TableName() string {
 	return "proxy_services"
 }
 
+// ProxyServiceRoute sends requests under a path prefix of a service to a
+// different upstream. Routes share the service's upstream TLS settings.
+type ProxyServiceRoute struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	ServiceID   string `json:"service_id" gorm:"type:varchar(8);index"`
+	PathPrefix  string `json:"path_prefix"`
+	LocalHost   string `json:"local_host"`
+	LocalPort   int    `json:"local_port"`
+	StripPrefix bool   `json:"strip_prefix"`
+	Position    int    `json:"position"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceRoute) TableName() string {
+	return "proxy_service_routes"
+}
+
+// ProxyServiceUpstream is one upstream target of a load balanced service
+type ProxyServiceUpstream struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ServiceID string `json:"service_id" gorm:"type:varchar(8);index"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Weight    int    `json:"weight"` // relative share under the weighted policy
+	Position  int    `json:"position"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceUpstream) TableName() string {
+	return "proxy_service_upstreams"
+}
+
+// ProxyServiceAccessRule allows or denies clients by address. A client
+// matching any deny rule is rejected; when allow rules exist, a client must
+// also match one of them.
+type ProxyServiceAccessRule struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ServiceID string `json:"service_id" gorm:"type:varchar(8);index"`
+	Action    string `json:"action" gorm:"type:varchar(8)"` // "allow" or "deny"
+	CIDR      string `json:"cidr" gorm:"type:varchar(64)"`
+	Position  int    `json:"position"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceAccessRule) TableName() string {
+	return "proxy_service_access_rules"
+}
+
+// ProxyServiceHeaderRule adds, sets or removes a request or response header.
+// Values may reference {host}, {client_ip} and {scheme} of the original request.
+type ProxyServiceHeaderRule struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ServiceID string `json:"service_id" gorm:"type:varchar(8);index"`
+	Direction string `json:"direction" gorm:"type:varchar(8)"` // "request" or "response"
+	Action    string `json:"action" gorm:"type:varchar(8)"`    // "add", "set" or "remove"
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	Position  int    `json:"position"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceHeaderRule) TableName() string {
+	return "proxy_service_header_rules"
+}
+
+// ProxyServiceErrorPage is an HTML template served in place of the built-in
+// response for one status. Templates may reference {{.Service}}, {{.Status}},
+// {{.StatusText}} and {{.RetryAfter}}.
+type ProxyServiceErrorPage struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ServiceID string `json:"service_id" gorm:"type:varchar(8);index"`
+	Status    int    `json:"status"` // 502, 503 or 504
+	Template  string `json:"template"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceErrorPage) TableName() string {
+	return "proxy_service_error_pages"
+}
+
+// ProxyServiceSchedule is a window of time during which a scheduled service
+// is enabled. A window ending before it starts runs past midnight into the
+// next day.
+type ProxyServiceSchedule struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ServiceID string `json:"service_id" gorm:"type:varchar(8);index"`
+	Days      string `json:"days" gorm:"type:varchar(32)"` // weekdays such as "mon-fri" or "sat,sun", "*" for every day
+	Start     string `json:"start" gorm:"type:varchar(5)"` // HH:MM
+	End       string `json:"end" gorm:"type:varchar(5)"`   // HH:MM, "24:00" for the end of the day
+	Position  int    `json:"position"`
+}
+
+// TableName overrides the table name
+func (ProxyServiceSchedule) TableName() string {
+	return "proxy_service_schedules"
+}
+
 // ProxyServiceConfig represents partial update configuration
 type ProxyServiceConfig struct {
-	Name      *string `json:"name,omitempty"`
-	LocalHost *string `json:"local_host,omitempty"`
-	LocalPort *int    `json:"local_port,omitempty"`
-	Enabled   *bool   `json:"enabled,omitempty"`
+	Name       *string `json:"name,omitempty"`
+	LocalHost  *string `json:"local_host,omitempty"`
+	LocalPort  *int    `json:"local_port,omitempty"`
+	TunnelPort *int    `json:"tunnel_port,omitempty"`
+	Enabled    *bool   `json:"enabled,omitempty"`
+	Hostname   *string `json:"hostname,omitempty"`
+
+	UpstreamTLS         *string `json:"upstream_tls,omitempty"`
+	UpstreamCA          *string `json:"upstream_ca,omitempty"`
+	UpstreamFingerprint *string `json:"upstream_fingerprint,omitempty"`
+	UpstreamSNI         *string `json:"upstream_sni,omitempty"`
+
+	TLSEnabled *bool `json:"tls_enabled,omitempty"`
+
+	Routes *[]ProxyServiceRoute `json:"routes,omitempty"` // replaces all routes when set
+
+	Upstreams     *[]ProxyServiceUpstream `json:"upstreams,omitempty"` // replaces all upstreams when set
+	LoadBalancing *string                 `json:"load_balancing,omitempty"`
+
+	HealthCheck         *string `json:"health_check,omitempty"`
+	HealthCheckPath     *string `json:"health_check_path,omitempty"`
+	HealthCheckInterval *int    `json:"health_check_interval,omitempty"`
+	HealthCheckTimeout  *int    `json:"health_check_timeout,omitempty"`
+	HealthCheckStatus   *int    `json:"health_check_status,omitempty"`
+	HealthyThreshold    *int    `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold  *int    `json:"unhealthy_threshold,omitempty"`
+
+	AuthMode     *string `json:"auth_mode,omitempty"`
+	AuthUsername *string `json:"auth_username,omitempty"`
+	AuthSecret   *string `json:"auth_secret,omitempty"` // plaintext, stored hashed
+
+	AccessRules *[]ProxyServiceAccessRule `json:"access_rules,omitempty"` // replaces all access rules when set
+
+	HeaderRules *[]ProxyServiceHeaderRule `json:"header_rules,omitempty"` // replaces all header rules when set
+
+	TimeoutProfile        *string `json:"timeout_profile,omitempty"`
+	ReadHeaderTimeout     *int    `json:"read_header_timeout,omitempty"`
+	IdleTimeout           *int    `json:"idle_timeout,omitempty"`
+	WriteTimeout          *int    `json:"write_timeout,omitempty"`
+	UpstreamHeaderTimeout *int    `json:"upstream_header_timeout,omitempty"`
+	FlushInterval         *int    `json:"flush_interval,omitempty"`
+
+	RateLimit *int `json:"rate_limit,omitempty"`
+	RateBurst *int `json:"rate_burst,omitempty"`
+	ConnLimit *int `json:"conn_limit,omitempty"`
+
+	BreakerThreshold *int `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  *int `json:"breaker_cooldown,omitempty"`
+	RetryAttempts    *int `json:"retry_attempts,omitempty"`
+
+	ShadowHost    *string `json:"shadow_host,omitempty"`
+	ShadowPort    *int    `json:"shadow_port,omitempty"`
+	ShadowPercent *int    `json:"shadow_percent,omitempty"`
+
+	CaptureEnabled *bool `json:"capture_enabled,omitempty"`
+	CaptureSize    *int  `json:"capture_size,omitempty"`
+
+	Compression        *bool   `json:"compression,omitempty"`
+	CompressionTypes   *string `json:"compression_types,omitempty"`
+	CompressionMinSize *int    `json:"compression_min_size,omitempty"`
+
+	CacheEnabled *bool `json:"cache_enabled,omitempty"`
+	CacheSizeMB  *int  `json:"cache_size_mb,omitempty"`
+
+	Maintenance           *bool                    `json:"maintenance,omitempty"`
+	MaintenanceRetryAfter *int                     `json:"maintenance_retry_after,omitempty"`
+	ErrorPages            *[]ProxyServiceErrorPage `json:"error_pages,omitempty"` // replaces all error pages when set
+
+	Schedules        *[]ProxyServiceSchedule `json:"schedules,omitempty"` // replaces all schedules when set
+	ScheduleTimezone *string                 `json:"schedule_timezone,omitempty"`
+
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"` // the zero time removes the expiry
+	MaxUses      *int       `json:"max_uses,omitempty"`
+	ExpireAction *string    `json:"expire_action,omitempty"`
 }
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// accessFilter applies a service's client IP rules on its tunnel listeners
+type accessFilter struct {
+	name   string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+	stats  *serviceStats
+	logger *logger.Logger
+}
+
+// newAccessFilter builds the filter for a service, or returns nil if the
+// service has no access rules
+func (p *ProxyProvider) newAccessFilter(service *models.ProxyService) *accessFilter {
+	if len(service.AccessRules) == 0 {
+		return nil
+	}
+
+	f := &accessFilter{
+		name:   service.Name,
+		stats:  p.statsFor(service.ID),
+		logger: p.logger,
+	}
+	for _, rule := range service.AccessRules {
+		_, ipNet, err := net.ParseCIDR(rule.CIDR)
+		if err != nil {
+			p.logger.Printf("Ignoring invalid access rule %s for service %s", rule.CIDR, service.Name)
+			continue
+		}
+		if rule.Action == models.AccessDeny {
+			f.deny = append(f.deny, ipNet)
+		} else {
+			f.allow = append(f.allow, ipNet)
+		}
+	}
+
+	return f
+}
+
+// permits reports whether a client IP passes the rules
+func (f *accessFilter) permits(ip net.IP) bool {
+	for _, ipNet := range f.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range f.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows checks a client address, counting and logging it when denied. A nil
+// filter allows everyone.
+func (f *accessFilter) allows(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+
+	if ip != nil && f.permits(ip) {
+		return true
+	}
+
+	f.stats.denied.Add(1)
+	f.logger.Printf("[Proxy] %s -> denied client %s", f.name, addr)
+	return false
+}
+
+// allowsRemoteAddr checks the remote address of an HTTP request
+func (f *accessFilter) allowsRemoteAddr(remoteAddr string) bool {
+	if f == nil {
+		return true
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", remoteAddr)
+	if err != nil {
+		f.stats.denied.Add(1)
+		f.logger.Printf("[Proxy] %s -> denied client with invalid address %s", f.name, remoteAddr)
+		return false
+	}
+	return f.allows(addr)
+}
+
+// wrap rejects HTTP requests from denied clients before they reach next
+func (f *accessFilter) wrap(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.allowsRemoteAddr(r.RemoteAddr) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
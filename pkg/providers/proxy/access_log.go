@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/config"
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
+)
+
+const (
+	// accessLogQueueSize bounds the entries waiting to be written; more are dropped
+	accessLogQueueSize = 4096
+	// accessLogBatchSize is the most entries written in one insert
+	accessLogBatchSize = 200
+	// accessLogFlushInterval is how long entries wait before being written
+	accessLogFlushInterval = time.Second
+	// accessLogPruneInterval is how often old entries are rotated out
+	accessLogPruneInterval = 10 * time.Minute
+
+	defaultAccessLogMaxRows = 100000
+	defaultAccessLogMaxAge  = 7 * 24 * time.Hour
+)
+
+// accessLogWriter batches access log entries into the database in the
+// background so requests never wait on SQLite
+type accessLogWriter struct {
+	repo    *repositories.AccessLogRepository
+	logger  *logger.Logger
+	entries chan models.AccessLogEntry
+	dropped atomic.Int64
+	maxRows int
+	maxAge  time.Duration
+}
+
+// newAccessLogWriter builds the writer with the rotation settings from cfg
+func newAccessLogWriter(repo *repositories.AccessLogRepository, cfg *config.Config, log *logger.Logger) *accessLogWriter {
+	w := &accessLogWriter{
+		repo:    repo,
+		logger:  log,
+		entries: make(chan models.AccessLogEntry, accessLogQueueSize),
+		maxRows: defaultAccessLogMaxRows,
+		maxAge:  defaultAccessLogMaxAge,
+	}
+	if cfg.AccessLogMaxRows > 0 {
+		w.maxRows = cfg.AccessLogMaxRows
+	}
+	if cfg.AccessLogMaxAgeDays > 0 {
+		w.maxAge = time.Duration(cfg.AccessLogMaxAgeDays) * 24 * time.Hour
+	}
+	return w
+}
+
+// record queues an entry, dropping it if the queue is full
+func (w *accessLogWriter) record(entry models.AccessLogEntry) {
+	select {
+	case w.entries <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// run writes queued entries and rotates the table until ctx is cancelled
+func (w *accessLogWriter) run(ctx context.Context) {
+	flushTicker := time.NewTicker(accessLogFlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(accessLogPruneInterval)
+	defer pruneTicker.Stop()
+
+	batch := make([]models.AccessLogEntry, 0, accessLogBatchSize)
+	flush := func() {
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			w.logger.Printf("[Proxy] Access log queue full, dropped %d entries", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.repo.AddEntries(batch); err != nil {
+			w.logger.Printf("[Proxy] Failed to write access log: %v", err)
+		}
+		batch = batch[:0]
+	}
+
+	w.prune()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Write whatever is still queued
+			for {
+				select {
+				case entry := <-w.entries:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		case entry := <-w.entries:
+			batch = append(batch, entry)
+			if len(batch) >= accessLogBatchSize {
+				flush()
+			}
+		case <-flushTicker.C:
+			flush()
+		case <-pruneTicker.C:
+			w.prune()
+		}
+	}
+}
+
+// prune rotates out entries beyond the configured age and row count
+func (w *accessLogWriter) prune() {
+	if err := w.repo.Prune(w.maxAge, w.maxRows); err != nil {
+		w.logger.Printf("[Proxy] Failed to rotate access log: %v", err)
+	}
+}
+
+// accessRecorder captures the status and size of a response
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.status == 0 || rec.status < 200 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (rec *accessRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Hijack records a protocol switch before handing over the connection
+func (rec *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *accessRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+// Read implements io.Reader
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+// instrument counts every request in the service's stats and records it in
+// the access log
+func (p *ProxyProvider) instrument(service *models.ProxyService, next http.Handler) http.Handler {
+	stats := p.statsFor(service.ID)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+
+		var body *countingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		// Taken before handlers further down rewrite the request
+		path := r.URL.Path
+		host := r.Host
+
+		next.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		var bytesIn int64
+		if body != nil {
+			bytesIn = body.bytes
+		}
+
+		stats.countRequest(status, elapsed.Seconds())
+		stats.bytesIn.Add(bytesIn)
+		stats.bytesOut.Add(rec.bytes)
+
+		if p.accessLog == nil {
+			return
+		}
+		p.accessLog.record(models.AccessLogEntry{
+			ServiceID: service.ID,
+			Time:      start,
+			ClientIP:  clientIP(r.RemoteAddr),
+			Method:    r.Method,
+			Host:      host,
+			Path:      path,
+			Status:    status,
+			BytesIn:   bytesIn,
+			BytesOut:  rec.bytes,
+			LatencyMs: float64(elapsed.Microseconds()) / 1000,
+		})
+	})
+}
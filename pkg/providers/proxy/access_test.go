@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestAccessFilter builds the filter for rules given as action and CIDR
+// pairs
+func newTestAccessFilter(rules ...[2]string) *accessFilter {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+
+	service := &models.ProxyService{ID: "test", Name: "test"}
+	for _, rule := range rules {
+		service.AccessRules = append(service.AccessRules, models.ProxyServiceAccessRule{Action: rule[0], CIDR: rule[1]})
+	}
+	return p.newAccessFilter(service)
+}
+
+func TestAccessFilterAllows(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules [][2]string
+		addr  net.Addr
+		want  bool
+	}{
+		{name: "no rules", addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7")}, want: true},
+		{name: "allowed", rules: [][2]string{{models.AccessAllow, "10.0.0.0/8"}}, addr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, want: true},
+		{name: "not in allow list", rules: [][2]string{{models.AccessAllow, "10.0.0.0/8"}}, addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, want: false},
+		{name: "deny only", rules: [][2]string{{models.AccessDeny, "192.168.1.0/24"}}, addr: &net.TCPAddr{IP: net.ParseIP("192.168.2.1")}, want: true},
+		{name: "denied", rules: [][2]string{{models.AccessDeny, "192.168.1.0/24"}}, addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.9")}, want: false},
+		{
+			name:  "deny wins over allow",
+			rules: [][2]string{{models.AccessAllow, "10.0.0.0/8"}, {models.AccessDeny, "10.0.0.5/32"}},
+			addr:  &net.TCPAddr{IP: net.ParseIP("10.0.0.5")},
+			want:  false,
+		},
+		{name: "udp", rules: [][2]string{{models.AccessAllow, "10.0.0.0/8"}}, addr: &net.UDPAddr{IP: net.ParseIP("10.9.9.9")}, want: true},
+		{name: "ipv6", rules: [][2]string{{models.AccessAllow, "fd00::/8"}}, addr: &net.TCPAddr{IP: net.ParseIP("fd12::1")}, want: true},
+		{name: "ipv4 mapped ipv6", rules: [][2]string{{models.AccessAllow, "10.0.0.0/8"}}, addr: &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1")}, want: true},
+		{name: "invalid rule ignored", rules: [][2]string{{models.AccessDeny, "bogus"}}, addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestAccessFilter(tt.rules...).allows(tt.addr); got != tt.want {
+				t.Fatalf("allows(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessFilterWrap(t *testing.T) {
+	f := newTestAccessFilter([2]string{models.AccessAllow, "10.0.0.0/8"})
+	handler := f.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{remoteAddr: "10.0.0.1:50000", want: http.StatusNoContent},
+		{remoteAddr: "192.168.1.1:50000", want: http.StatusForbidden},
+		{remoteAddr: "not an address", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	if denied := f.stats.denied.Load(); denied != 2 {
+		t.Fatalf("denied = %d, want 2", denied)
+	}
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/utils"
+)
+
+const (
+	// authSessionCookiePrefix names the cookie set by the built-in login
+	// page. The service ID is appended so services on the same edge address,
+	// which share cookies, keep separate sessions.
+	authSessionCookiePrefix = "arqut_session_"
+	// authSessionTTL is how long a login page session stays valid
+	authSessionTTL = 24 * time.Hour
+	// authLoginPath serves the built-in login page on every gated service
+	authLoginPath = "/.arqut/login"
+	// authLogoutPath clears the login page session
+	authLogoutPath = "/.arqut/logout"
+)
+
+// loginPage is the built-in password login form
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} - Sign in</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+form { background: #fff; padding: 2em; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,.1); min-width: 280px; }
+h1 { font-size: 1.2em; margin-top: 0; }
+input, button { width: 100%; box-sizing: border-box; padding: .6em; margin-top: .6em; font-size: 1em; }
+.error { color: #c10015; }
+</style>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<h1>{{.Name}}</h1>
+{{if .Failed}}<p class="error">Incorrect password</p>{{end}}
+<input type="hidden" name="next" value="{{.Next}}">
+<input type="password" name="password" placeholder="Password" autofocus required>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// authGate requires clients to authenticate before requests reach the
+// upstream. Credentials are checked against the stored bcrypt hash; the last
+// accepted credential is remembered by digest so bcrypt does not run on every
+// request.
+type authGate struct {
+	name     string
+	mode     string
+	username string
+	hash     string
+	cookie   string // name of the login page session cookie
+	next     http.Handler
+	logger   *logger.Logger
+
+	verified string // SHA-256 of the last accepted credential
+	mu       sync.RWMutex
+}
+
+// newAuthGate wraps next with the access gate configured on a service, or
+// returns next unchanged if the service has none
+func newAuthGate(service *models.ProxyService, next http.Handler, log *logger.Logger) http.Handler {
+	if service.AuthMode == models.AuthModeNone {
+		return next
+	}
+
+	return &authGate{
+		name:     service.Name,
+		mode:     service.AuthMode,
+		username: service.AuthUsername,
+		hash:     service.AuthSecretHash,
+		cookie:   authSessionCookiePrefix + service.ID,
+		next:     next,
+		logger:   log,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (g *authGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch g.mode {
+	case models.AuthModeBasic:
+		username, password, ok := r.BasicAuth()
+		if !ok || !g.checkBasic(username, password) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", g.name))
+			g.deny(w, r)
+			return
+		}
+		r.Header.Del("Authorization")
+
+	case models.AuthModeBearer:
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !g.checkSecret(strings.TrimSpace(token)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			g.deny(w, r)
+			return
+		}
+		r.Header.Del("Authorization")
+
+	case models.AuthModePassword:
+		switch r.URL.Path {
+		case authLoginPath:
+			g.serveLogin(w, r)
+			return
+		case authLogoutPath:
+			g.setSession(w, r, "", -1)
+			http.Redirect(w, r, authLoginPath, http.StatusSeeOther)
+			return
+		}
+
+		if !g.checkSession(r) {
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, authLoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			g.deny(w, r)
+			return
+		}
+		removeCookie(r, g.cookie)
+
+	default:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	g.next.ServeHTTP(w, r)
+}
+
+// deny rejects an unauthenticated request
+func (g *authGate) deny(w http.ResponseWriter, r *http.Request) {
+	g.logger.Debug("[Proxy] %s -> unauthenticated request from %s for %s", g.name, r.RemoteAddr, r.URL.Path)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// checkBasic verifies basic auth credentials
+func (g *authGate) checkBasic(username, password string) bool {
+	if subtle.ConstantTimeCompare([]byte(username), []byte(g.username)) != 1 {
+		return false
+	}
+	return g.checkSecret(password)
+}
+
+// checkSecret verifies a password or token against the stored hash
+func (g *authGate) checkSecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	digest := hex.EncodeToString(sum[:])
+
+	g.mu.RLock()
+	verified := g.verified
+	g.mu.RUnlock()
+	if verified != "" && subtle.ConstantTimeCompare([]byte(digest), []byte(verified)) == 1 {
+		return true
+	}
+
+	if utils.VerifyPassword(g.hash, secret) != nil {
+		return false
+	}
+
+	g.mu.Lock()
+	g.verified = digest
+	g.mu.Unlock()
+	return true
+}
+
+// serveLogin renders the login page and handles its form submission
+func (g *authGate) serveLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+
+	failed := false
+	if r.Method == http.MethodPost {
+		if g.checkSecret(r.PostFormValue("password")) {
+			expires := time.Now().Add(authSessionTTL)
+			g.setSession(w, r, g.signSession(expires), int(authSessionTTL.Seconds()))
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		g.logger.Printf("[Proxy] %s -> failed login from %s", g.name, r.RemoteAddr)
+		failed = true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	_ = loginPage.Execute(w, map[string]any{
+		"Name":   g.name,
+		"Action": authLoginPath,
+		"Next":   next,
+		"Failed": failed,
+	})
+}
+
+// signSession returns a session cookie value valid until expires. Sessions
+// are signed with the stored hash, so changing the secret logs everyone out.
+func (g *authGate) signSession(expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(g.hash))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSession verifies the session cookie of a request
+func (g *authGate) checkSession(r *http.Request) bool {
+	cookie, err := r.Cookie(g.cookie)
+	if err != nil {
+		return false
+	}
+
+	payload, _, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(cookie.Value), []byte(g.signSession(time.Unix(expires, 0))))
+}
+
+// setSession writes the session cookie; a negative maxAge clears it
+func (g *authGate) setSession(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     g.cookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// removeCookie drops a cookie from a request before it is forwarded
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/utils"
+)
+
+// newTestAuthGate returns a gate in mode with secret "s3cret" in front of a
+// handler echoing the Authorization and Cookie headers it receives
+func newTestAuthGate(mode string) http.Handler {
+	service := &models.ProxyService{
+		ID:             "svc",
+		Name:           "test",
+		AuthMode:       mode,
+		AuthUsername:   "admin",
+		AuthSecretHash: utils.HashPassword("s3cret"),
+	}
+	return newAuthGate(service, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Authorization", r.Header.Get("Authorization"))
+		w.Header().Set("X-Upstream-Cookie", r.Header.Get("Cookie"))
+	}), newTestLogger())
+}
+
+func TestAuthGateCredentials(t *testing.T) {
+	basic := func(username, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		auth       func(*http.Request)
+		wantStatus int
+		challenge  string // expected WWW-Authenticate prefix
+	}{
+		{name: "basic ok", mode: models.AuthModeBasic, auth: basic("admin", "s3cret"), wantStatus: http.StatusOK},
+		{name: "basic ok again", mode: models.AuthModeBasic, auth: basic("admin", "s3cret"), wantStatus: http.StatusOK},
+		{name: "basic wrong password", mode: models.AuthModeBasic, auth: basic("admin", "wrong"), wantStatus: http.StatusUnauthorized, challenge: "Basic"},
+		{name: "basic wrong user", mode: models.AuthModeBasic, auth: basic("root", "s3cret"), wantStatus: http.StatusUnauthorized, challenge: "Basic"},
+		{name: "basic missing", mode: models.AuthModeBasic, wantStatus: http.StatusUnauthorized, challenge: "Basic"},
+		{name: "bearer ok", mode: models.AuthModeBearer, auth: bearer("s3cret"), wantStatus: http.StatusOK},
+		{name: "bearer wrong", mode: models.AuthModeBearer, auth: bearer("nope"), wantStatus: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "bearer empty", mode: models.AuthModeBearer, auth: bearer(""), wantStatus: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "bearer as basic", mode: models.AuthModeBearer, auth: basic("admin", "s3cret"), wantStatus: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "unknown mode", mode: "oauth", auth: basic("admin", "s3cret"), wantStatus: http.StatusForbidden},
+	}
+
+	gates := map[string]http.Handler{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate, ok := gates[tt.mode]
+			if !ok {
+				gate = newTestAuthGate(tt.mode)
+				gates[tt.mode] = gate
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			w := httptest.NewRecorder()
+			gate.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, tt.challenge) || (tt.challenge == "") != (got == "") {
+				t.Fatalf("challenge %q, want %q", got, tt.challenge)
+			}
+			if w.Code == http.StatusOK && w.Header().Get("X-Upstream-Authorization") != "" {
+				t.Fatal("credentials forwarded to the upstream")
+			}
+		})
+	}
+}
+
+func TestAuthGateNone(t *testing.T) {
+	next := http.NotFoundHandler()
+	service := &models.ProxyService{AuthMode: models.AuthModeNone}
+	if gate := newAuthGate(service, next, newTestLogger()); gate == nil {
+		t.Fatal("no handler without a gate")
+	} else if _, gated := gate.(*authGate); gated {
+		t.Fatal("service without auth is gated")
+	}
+}
+
+func TestAuthGatePasswordLogin(t *testing.T) {
+	gate := newTestAuthGate(models.AuthModePassword)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		gate.ServeHTTP(w, req)
+		return w
+	}
+
+	// Browsers are sent to the login page, other clients are refused
+	page := httptest.NewRequest(http.MethodGet, "http://app.example.com/docs?page=2", nil)
+	page.Header.Set("Accept", "text/html")
+	w := serve(page)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != authLoginPath+"?next="+url.QueryEscape("/docs?page=2") {
+		t.Fatalf("browser: status %d location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "http://app.example.com/api", nil)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("API client: status %d, want 401", w.Code)
+	}
+
+	login := func(password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "next": {next}}
+		req := httptest.NewRequest(http.MethodPost, "http://app.example.com"+authLoginPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(req)
+	}
+
+	if w := login("wrong", "/docs"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Incorrect password") {
+		t.Fatalf("wrong password: status %d", w.Code)
+	}
+	if w := login("s3cret", "//evil.example/"); w.Header().Get("Location") != "/" {
+		t.Fatalf("open redirect to %q", w.Header().Get("Location"))
+	}
+
+	w = login("s3cret", "/docs")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/docs" {
+		t.Fatalf("login: status %d location %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != authSessionCookiePrefix+"svc" || !cookies[0].HttpOnly {
+		t.Fatalf("session cookies %v", cookies)
+	}
+	session := cookies[0]
+
+	// The session lets requests through without reaching the upstream
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/api", nil)
+	req.AddCookie(session)
+	req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	w = serve(req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Upstream-Cookie") != "theme=dark" {
+		t.Fatalf("with session: status %d, upstream cookies %q", w.Code, w.Header().Get("X-Upstream-Cookie"))
+	}
+
+	tampered := *session
+	tampered.Value = "9999999999" + session.Value[strings.Index(session.Value, "."):]
+	expired := *session
+	expired.Value = gate.(*authGate).signSession(time.Now().Add(-time.Minute))
+	for name, cookie := range map[string]*http.Cookie{"tampered": &tampered, "expired": &expired} {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/api", nil)
+		req.AddCookie(cookie)
+		if w := serve(req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s session: status %d, want 401", name, w.Code)
+		}
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "http://app.example.com"+authLogoutPath, nil))
+	if cookies := w.Result().Cookies(); w.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("logout: status %d cookies %v", w.Code, cookies)
+	}
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// upstreamMaxFails is how many consecutive failures eject a target
+	upstreamMaxFails = 3
+	// upstreamEjectDuration is how long an ejected target is skipped
+	upstreamEjectDuration = 30 * time.Second
+)
+
+// upstreamTarget is one upstream address of a service and its runtime state
+type upstreamTarget struct {
+	addr   string // host:port
+	weight int
+
+	active       atomic.Int64 // in-flight requests or open connections
+	fails        atomic.Int32 // consecutive failures
+	ejectedUntil atomic.Int64 // unix nanoseconds
+	unhealthy    atomic.Bool  // set by active health checks
+
+	currentWeight int // smooth weighted round-robin state, guarded by balancer.mu
+}
+
+// available reports whether the target is neither ejected nor failing its
+// health checks
+func (t *upstreamTarget) available(now time.Time) bool {
+	return now.UnixNano() >= t.ejectedUntil.Load() && !t.unhealthy.Load()
+}
+
+// balancer spreads traffic over the upstream targets of a service and ejects
+// targets that keep failing. It is shared by every listener of the service.
+type balancer struct {
+	name    string
+	policy  string
+	targets []*upstreamTarget
+	logger  *logger.Logger
+
+	next atomic.Uint64 // round-robin cursor
+	mu   sync.Mutex
+}
+
+// newBalancer builds a balancer over a service's upstream targets, falling
+// back to its local host and port when no upstreams are configured
+func newBalancer(service *models.ProxyService, log *logger.Logger) *balancer {
+	b := &balancer{
+		name:   service.Name,
+		policy: service.LoadBalancing,
+		logger: log,
+	}
+
+	for _, upstream := range service.Upstreams {
+		b.targets = append(b.targets, &upstreamTarget{
+			addr:   net.JoinHostPort(upstream.Host, strconv.Itoa(upstream.Port)),
+			weight: upstream.Weight,
+		})
+	}
+	if len(b.targets) == 0 {
+		b.targets = []*upstreamTarget{{
+			addr:   net.JoinHostPort(service.LocalHost, strconv.Itoa(service.LocalPort)),
+			weight: 1,
+		}}
+	}
+
+	return b
+}
+
+// newSingleTargetBalancer builds a balancer with one fixed target
+func newSingleTargetBalancer(name, host string, port int, log *logger.Logger) *balancer {
+	return &balancer{
+		name: name,
+		targets: []*upstreamTarget{{
+			addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+			weight: 1,
+		}},
+		logger: log,
+	}
+}
+
+// balancerFor returns the balancer of a service, creating it on first use
+func (p *ProxyProvider) balancerFor(service *models.ProxyService) *balancer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lb, exists := p.balancers[service.ID]
+	if !exists {
+		lb = newBalancer(service, p.logger)
+		p.balancers[service.ID] = lb
+	}
+	return lb
+}
+
+// upstreamKey identifies the main upstreams of a service, or the path route
+// with the given prefix
+func upstreamKey(serviceID, route string) string {
+	if route == "" {
+		return serviceID
+	}
+	return serviceID + " " + route
+}
+
+// routeBalancerFor returns the balancer of a path route of a service,
+// creating it on first use
+func (p *ProxyProvider) routeBalancerFor(service *models.ProxyService, route models.ProxyServiceRoute) *balancer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := upstreamKey(service.ID, route.PathPrefix)
+	lb, exists := p.balancers[key]
+	if !exists {
+		lb = newSingleTargetBalancer(service.Name, route.LocalHost, route.LocalPort, p.logger)
+		p.balancers[key] = lb
+	}
+	return lb
+}
+
+// serviceBalancers returns the balancers of a service: its main upstreams
+// followed by those of its path routes
+func (p *ProxyProvider) serviceBalancers(service *models.ProxyService) []*balancer {
+	lbs := []*balancer{p.balancerFor(service)}
+	for _, route := range service.Routes {
+		lbs = append(lbs, p.routeBalancerFor(service, route))
+	}
+	return lbs
+}
+
+// takeBalancers removes the balancers of a service and its path routes,
+// returning them by key
+func (p *ProxyProvider) takeBalancers(id string) map[string]*balancer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	taken := make(map[string]*balancer)
+	for key, lb := range p.balancers {
+		if key == id || strings.HasPrefix(key, id+" ") {
+			taken[key] = lb
+			delete(p.balancers, key)
+		}
+	}
+	return taken
+}
+
+// pick selects a target for a new request or connection, skipping any in
+// exclude. Ejected targets are only used when nothing else is left.
+func (b *balancer) pick(exclude ...*upstreamTarget) *upstreamTarget {
+	now := time.Now()
+
+	candidates := make([]*upstreamTarget, 0, len(b.targets))
+	fallback := make([]*upstreamTarget, 0, len(b.targets))
+	for _, target := range b.targets {
+		if containsTarget(exclude, target) {
+			continue
+		}
+		fallback = append(fallback, target)
+		if target.available(now) {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch b.policy {
+	case models.LoadBalanceLeastConn:
+		return b.pickLeastConn(candidates)
+	case models.LoadBalanceWeighted:
+		return b.pickWeighted(candidates)
+	default:
+		return candidates[(b.next.Add(1)-1)%uint64(len(candidates))]
+	}
+}
+
+// pickLeastConn returns the candidate with the fewest active requests,
+// rotating the starting point so ties are shared
+func (b *balancer) pickLeastConn(candidates []*upstreamTarget) *upstreamTarget {
+	start := int((b.next.Add(1) - 1) % uint64(len(candidates)))
+
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		target := candidates[(start+i)%len(candidates)]
+		if target.active.Load() < best.active.Load() {
+			best = target
+		}
+	}
+	return best
+}
+
+// pickWeighted implements smooth weighted round-robin over the candidates
+func (b *balancer) pickWeighted(candidates []*upstreamTarget) *upstreamTarget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *upstreamTarget
+	total := 0
+	for _, target := range candidates {
+		target.currentWeight += target.weight
+		total += target.weight
+		if best == nil || target.currentWeight > best.currentWeight {
+			best = target
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// acquire picks a target and counts the request against it until release
+func (b *balancer) acquire(exclude ...*upstreamTarget) *upstreamTarget {
+	target := b.pick(exclude...)
+	if target != nil {
+		target.active.Add(1)
+	}
+	return target
+}
+
+// release ends a request started with acquire
+func (b *balancer) release(target *upstreamTarget) {
+	target.active.Add(-1)
+}
+
+// markFailure records a failed request, ejecting the target once it has
+// failed too many times in a row
+func (b *balancer) markFailure(target *upstreamTarget, err error) {
+	if target.fails.Add(1) < upstreamMaxFails || len(b.targets) == 1 {
+		return
+	}
+
+	until := time.Now().Add(upstreamEjectDuration)
+	if prev := target.ejectedUntil.Swap(until.UnixNano()); prev < time.Now().UnixNano() {
+		b.logger.Printf("[Proxy] %s -> upstream %s ejected for %s: %v", b.name, target.addr, upstreamEjectDuration, err)
+	}
+}
+
+// markSuccess records a successful request, returning an ejected target to
+// the pool
+func (b *balancer) markSuccess(target *upstreamTarget) {
+	if target.fails.Swap(0) >= upstreamMaxFails && target.ejectedUntil.Swap(0) != 0 {
+		b.logger.Printf("[Proxy] %s -> upstream %s restored", b.name, target.addr)
+	}
+}
+
+// describe returns the target addresses for log messages
+func (b *balancer) describe() string {
+	addrs := make([]string, len(b.targets))
+	for i, target := range b.targets {
+		addrs[i] = target.addr
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// containsTarget reports whether target is in targets
+func containsTarget(targets []*upstreamTarget, target *upstreamTarget) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
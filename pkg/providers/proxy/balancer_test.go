@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestBalancer builds a balancer over upstreams a, b and c on localhost
+func newTestBalancer(policy string, weights ...int) *balancer {
+	service := &models.ProxyService{Name: "test", LoadBalancing: policy}
+	for i, weight := range weights {
+		service.Upstreams = append(service.Upstreams, models.ProxyServiceUpstream{
+			Host:   "127.0.0.1",
+			Port:   9001 + i,
+			Weight: weight,
+		})
+	}
+	return newBalancer(service, newTestLogger())
+}
+
+func TestBalancerPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		weights []int
+		active  []int64 // in-flight requests per target before picking
+		picks   int
+		want    []int // picks per target
+	}{
+		{name: "round robin", policy: models.LoadBalanceRoundRobin, weights: []int{1, 1, 1}, picks: 6, want: []int{2, 2, 2}},
+		{name: "default is round robin", policy: "", weights: []int{1, 1}, picks: 4, want: []int{2, 2}},
+		{name: "weighted", policy: models.LoadBalanceWeighted, weights: []int{5, 1, 1}, picks: 14, want: []int{10, 2, 2}},
+		{name: "least conn", policy: models.LoadBalanceLeastConn, weights: []int{1, 1, 1}, active: []int64{4, 1, 3}, picks: 1, want: []int{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := newTestBalancer(tt.policy, tt.weights...)
+			for i, active := range tt.active {
+				lb.targets[i].active.Store(active)
+			}
+
+			got := make([]int, len(lb.targets))
+			for range tt.picks {
+				target := lb.acquire()
+				for i, t := range lb.targets {
+					if t == target {
+						got[i]++
+					}
+				}
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("picks = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancerLeastConnTracksReleases(t *testing.T) {
+	lb := newTestBalancer(models.LoadBalanceLeastConn, 1, 1)
+
+	first := lb.acquire()
+	second := lb.acquire()
+	if first == second {
+		t.Fatalf("second request went to the busy target %s", first.addr)
+	}
+	lb.release(first)
+	if got := lb.acquire(); got != first {
+		t.Fatalf("acquire = %s, want released target %s", got.addr, first.addr)
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	errUpstream := errors.New("connection refused")
+
+	for _, policy := range []string{models.LoadBalanceRoundRobin, models.LoadBalanceWeighted, models.LoadBalanceLeastConn} {
+		t.Run(policy, func(t *testing.T) {
+			lb := newTestBalancer(policy, 1, 1, 1)
+			failing := lb.targets[0]
+
+			for range upstreamMaxFails - 1 {
+				lb.markFailure(failing, errUpstream)
+			}
+			if !failing.available(time.Now()) {
+				t.Fatalf("target ejected after %d failures, want %d", upstreamMaxFails-1, upstreamMaxFails)
+			}
+
+			lb.markFailure(failing, errUpstream)
+			if failing.available(time.Now()) {
+				t.Fatalf("target not ejected after %d failures", upstreamMaxFails)
+			}
+			for range 12 {
+				target := lb.acquire()
+				if target == failing {
+					t.Fatalf("ejected target %s picked", failing.addr)
+				}
+				lb.release(target)
+			}
+
+			lb.markSuccess(failing)
+			if !failing.available(time.Now()) {
+				t.Fatal("target still ejected after a success")
+			}
+		})
+	}
+}
+
+func TestBalancerFallbacks(t *testing.T) {
+	errUpstream := errors.New("connection refused")
+
+	t.Run("single target is never ejected", func(t *testing.T) {
+		lb := newSingleTargetBalancer("test", "127.0.0.1", 9001, newTestLogger())
+		for range upstreamMaxFails * 2 {
+			lb.markFailure(lb.targets[0], errUpstream)
+		}
+		if !lb.targets[0].available(time.Now()) {
+			t.Fatal("single target ejected")
+		}
+	})
+
+	t.Run("ejected targets are used when nothing else is left", func(t *testing.T) {
+		lb := newTestBalancer(models.LoadBalanceRoundRobin, 1, 1)
+		for _, target := range lb.targets {
+			target.ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+		}
+		if lb.acquire() == nil {
+			t.Fatal("acquire = nil with every target ejected")
+		}
+	})
+
+	t.Run("unhealthy targets are skipped", func(t *testing.T) {
+		lb := newTestBalancer(models.LoadBalanceRoundRobin, 1, 1)
+		lb.targets[1].unhealthy.Store(true)
+		for range 4 {
+			if got := lb.acquire(); got != lb.targets[0] {
+				t.Fatalf("acquire = %s, want healthy target %s", got.addr, lb.targets[0].addr)
+			}
+		}
+	})
+
+	t.Run("excluded targets are skipped", func(t *testing.T) {
+		lb := newTestBalancer(models.LoadBalanceRoundRobin, 1, 1)
+		if got := lb.acquire(lb.targets[0]); got != lb.targets[1] {
+			t.Fatalf("acquire = %s, want %s", got.addr, lb.targets[1].addr)
+		}
+		if got := lb.acquire(lb.targets...); got != nil {
+			t.Fatalf("acquire = %s with every target excluded, want nil", got.addr)
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// defaultBreakerCooldown is how long an open breaker rejects requests
+	// before letting a trial request through
+	defaultBreakerCooldown = 30 * time.Second
+	// retryBaseDelay is the wait before the first retry, doubled for each
+	// following one
+	retryBaseDelay = 100 * time.Millisecond
+	// retryMaxDelay caps the wait between retries
+	retryMaxDelay = 2 * time.Second
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // requests flow normally
+	BreakerOpen     = "open"      // requests are rejected until the cooldown ends
+	BreakerHalfOpen = "half_open" // one trial request decides whether to close again
+)
+
+// errBreakerOpen is returned for requests rejected by an open breaker
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// ProxyServiceBreaker is the circuit breaker state of a service
+type ProxyServiceBreaker struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"` // consecutive failed requests
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+// circuitBreaker stops sending requests to the upstreams of a service after
+// too many consecutive failures, then probes them again after a cooldown.
+// Only transport failures count; any upstream response closes the breaker.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	stats     *serviceStats
+	logger    *logger.Logger
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool // the half-open trial request is in flight
+	mu       sync.Mutex
+}
+
+// configure applies the breaker settings of a service
+func (cb *circuitBreaker) configure(service *models.ProxyService, name string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.name = name
+	cb.threshold = service.BreakerThreshold
+	cb.cooldown = time.Duration(service.BreakerCooldown) * time.Second
+	if cb.cooldown <= 0 {
+		cb.cooldown = defaultBreakerCooldown
+	}
+}
+
+// allow reports whether a request may go to the upstream. A nil breaker
+// allows everything.
+func (cb *circuitBreaker) allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.probing = true
+		return true
+	case BreakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an allowed request.
+// Requests cancelled by the client say nothing about the upstream.
+func (cb *circuitBreaker) record(err error) {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+	switch {
+	case err == nil:
+		if cb.state != BreakerClosed && cb.state != "" {
+			cb.logger.Printf("[Proxy] %s -> circuit breaker closed", cb.name)
+		}
+		cb.state = BreakerClosed
+		cb.failures = 0
+
+	case errors.Is(err, context.Canceled):
+
+	default:
+		cb.failures++
+		if cb.state == BreakerHalfOpen || (cb.state != BreakerOpen && cb.failures >= cb.threshold) {
+			cb.state = BreakerOpen
+			cb.openedAt = time.Now()
+			cb.stats.breakerTrips.Add(1)
+			cb.logger.Printf("[Proxy] %s -> circuit breaker opened for %s after %d failures: %v",
+				cb.name, cb.cooldown, cb.failures, err)
+		}
+	}
+}
+
+// retryAfter returns how long an open breaker keeps rejecting requests
+func (cb *circuitBreaker) retryAfter() time.Duration {
+	if cb == nil {
+		return 0
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != BreakerOpen {
+		return 0
+	}
+	return max(cb.cooldown-time.Since(cb.openedAt), 0)
+}
+
+// snapshot returns the current breaker state
+func (cb *circuitBreaker) snapshot() *ProxyServiceBreaker {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	s := &ProxyServiceBreaker{State: cb.state, Failures: cb.failures}
+	if s.State == "" {
+		s.State = BreakerClosed
+	}
+	if s.State == BreakerOpen {
+		until := cb.openedAt.Add(cb.cooldown)
+		s.OpenUntil = &until
+	}
+	return s
+}
+
+// breakerFor returns the circuit breaker of a service's main upstreams, or of
+// one of its path routes, or nil if it has none. Each upstream has its own
+// breaker, so a failing route does not reject requests to the others. The
+// breaker keeps its state while the service runs.
+func (p *ProxyProvider) breakerFor(service *models.ProxyService, route string) *circuitBreaker {
+	stats := p.statsFor(service.ID)
+	key := upstreamKey(service.ID, route)
+	name := service.Name
+	if route != "" {
+		name += " " + route
+	}
+
+	p.mu.Lock()
+	if service.BreakerThreshold <= 0 {
+		delete(p.breakers, key)
+		p.mu.Unlock()
+		return nil
+	}
+	cb, exists := p.breakers[key]
+	if !exists {
+		cb = &circuitBreaker{name: name, stats: stats, logger: p.logger}
+		p.breakers[key] = cb
+	}
+	p.mu.Unlock()
+
+	cb.configure(service, name)
+	return cb
+}
+
+// pruneRouteBreakers drops the breakers of path routes a service no longer has
+func (p *ProxyProvider) pruneRouteBreakers(service *models.ProxyService) {
+	routes := make(map[string]bool, len(service.Routes))
+	for _, route := range service.Routes {
+		routes[upstreamKey(service.ID, route.PathPrefix)] = true
+	}
+	p.dropBreakers(service.ID, routes)
+}
+
+// dropBreakers removes the route breakers of a service that are not in keep,
+// and its main breaker when keep is nil
+func (p *ProxyProvider) dropBreakers(id string, keep map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if keep == nil {
+		delete(p.breakers, id)
+	}
+	for key := range p.breakers {
+		if strings.HasPrefix(key, id+" ") && !keep[key] {
+			delete(p.breakers, key)
+		}
+	}
+}
+
+// GetServiceBreaker returns the state of the circuit breaker of a service's
+// main upstreams, or nil if it has no breaker running
+func (p *ProxyProvider) GetServiceBreaker(id string) *ProxyServiceBreaker {
+	p.mu.RLock()
+	cb, exists := p.breakers[id]
+	p.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return cb.snapshot()
+}
+
+// resilientTransport sends upstream requests through a service's circuit
+// breaker and retries failed GET and HEAD requests, moving to another
+// upstream target when the balancer has one
+type resilientTransport struct {
+	next    http.RoundTripper
+	lb      *balancer
+	breaker *circuitBreaker
+	retries int
+	stats   *serviceStats
+	logger  *logger.Logger
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		t.stats.breakerRejected.Add(1)
+		return nil, errBreakerOpen
+	}
+
+	resp, err := t.roundTrip(req)
+	t.breaker.record(err)
+	return resp, err
+}
+
+// roundTrip sends a request, retrying it with backoff while it is safe to
+func (t *resilientTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if retryable(req) {
+		attempts += t.retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt >= attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		pr := proxyRequestFrom(req.Context())
+		t.lb.markFailure(pr.target, err)
+		t.stats.retries.Add(1)
+		t.logger.Debug("[Proxy] %s -> retrying %s %s after attempt %d: %v", t.lb.name, req.Method, req.URL.Path, attempt, err)
+
+		if !sleepContext(req.Context(), retryDelay(attempt)) {
+			return nil, err
+		}
+
+		if target := t.lb.acquire(pr.target); target != nil {
+			t.lb.release(pr.target)
+			pr.target = target
+			req = req.Clone(req.Context())
+			req.URL.Host = target.addr
+			req.Host = target.addr
+		}
+	}
+}
+
+// retryable reports whether a failed upstream request may be sent again
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// retryDelay returns the jittered backoff before a retry
+func retryDelay(attempt int) time.Duration {
+	d := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleepContext waits for d, returning false if ctx ends first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
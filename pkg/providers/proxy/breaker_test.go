@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestBreaker returns a breaker opening after threshold failures
+func newTestBreaker(threshold int) *circuitBreaker {
+	cb := &circuitBreaker{stats: newServiceStats(), logger: newTestLogger()}
+	cb.configure(&models.ProxyService{BreakerThreshold: threshold}, "test")
+	return cb
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	errUpstream := errors.New("connection refused")
+
+	// A step either asks the breaker for a request (allow), records an
+	// outcome, or lets the cooldown pass
+	type step struct {
+		allow    *bool
+		record   error
+		success  bool
+		cooldown bool
+		state    string
+	}
+	allowed, rejected := true, false
+
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+		trips     int64
+	}{
+		{
+			name:      "opens at the threshold",
+			threshold: 2,
+			steps: []step{
+				{allow: &allowed, record: errUpstream, state: BreakerClosed},
+				{allow: &allowed, record: errUpstream, state: BreakerOpen},
+				{allow: &rejected, state: BreakerOpen},
+			},
+			trips: 1,
+		},
+		{
+			name:      "success resets the failures",
+			threshold: 2,
+			steps: []step{
+				{allow: &allowed, record: errUpstream, state: BreakerClosed},
+				{allow: &allowed, success: true, state: BreakerClosed},
+				{allow: &allowed, record: errUpstream, state: BreakerClosed},
+			},
+		},
+		{
+			name:      "cancelled requests do not count",
+			threshold: 1,
+			steps: []step{
+				{allow: &allowed, record: context.Canceled, state: BreakerClosed},
+				{allow: &allowed, record: context.Canceled, state: BreakerClosed},
+			},
+		},
+		{
+			name:      "half open trial closes it",
+			threshold: 1,
+			steps: []step{
+				{allow: &allowed, record: errUpstream, state: BreakerOpen},
+				{cooldown: true},
+				{allow: &allowed, state: BreakerHalfOpen},
+				{allow: &rejected, state: BreakerHalfOpen},
+				{success: true, state: BreakerClosed},
+				{allow: &allowed, state: BreakerClosed},
+			},
+			trips: 1,
+		},
+		{
+			name:      "failed trial opens it again",
+			threshold: 3,
+			steps: []step{
+				{allow: &allowed, record: errUpstream},
+				{allow: &allowed, record: errUpstream},
+				{allow: &allowed, record: errUpstream, state: BreakerOpen},
+				{cooldown: true},
+				{allow: &allowed, record: errUpstream, state: BreakerOpen},
+				{allow: &rejected, state: BreakerOpen},
+			},
+			trips: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := newTestBreaker(tt.threshold)
+			for i, s := range tt.steps {
+				if s.cooldown {
+					cb.openedAt = cb.openedAt.Add(-cb.cooldown)
+					continue
+				}
+				if s.allow != nil {
+					if got := cb.allow(); got != *s.allow {
+						t.Fatalf("step %d: allow = %v, want %v", i+1, got, *s.allow)
+					}
+				}
+				if s.record != nil || s.success {
+					cb.record(s.record)
+				}
+				if s.state != "" {
+					if got := cb.snapshot().State; got != s.state {
+						t.Fatalf("step %d: state = %s, want %s", i+1, got, s.state)
+					}
+				}
+			}
+			if got := cb.stats.breakerTrips.Load(); got != tt.trips {
+				t.Fatalf("trips = %d, want %d", got, tt.trips)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	cb := newTestBreaker(1)
+	if d := cb.retryAfter(); d != 0 {
+		t.Fatalf("closed retry after = %s, want 0", d)
+	}
+
+	cb.record(errors.New("timeout"))
+	if d := cb.retryAfter(); d <= 0 || d > defaultBreakerCooldown {
+		t.Fatalf("open retry after = %s, want within %s", d, defaultBreakerCooldown)
+	}
+	if s := cb.snapshot(); s.OpenUntil == nil || time.Until(*s.OpenUntil) > defaultBreakerCooldown {
+		t.Fatalf("open until = %v", s.OpenUntil)
+	}
+
+	var none *circuitBreaker
+	if !none.allow() || none.retryAfter() != 0 {
+		t.Fatal("nil breaker must allow everything")
+	}
+	none.record(errors.New("ignored"))
+}
+
+func TestBreakerPerRoute(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{
+		ID:               "svc",
+		Name:             "test",
+		BreakerThreshold: 1,
+		Routes:           []models.ProxyServiceRoute{{PathPrefix: "/api"}, {PathPrefix: "/ws"}},
+	}
+
+	main := p.breakerFor(service, "")
+	api := p.breakerFor(service, "/api")
+	ws := p.breakerFor(service, "/ws")
+	if main == api || api == ws {
+		t.Fatal("routes share a breaker")
+	}
+	if again := p.breakerFor(service, "/api"); again != api {
+		t.Fatal("route breaker not kept across calls")
+	}
+
+	api.record(errors.New("connection refused"))
+	if api.allow() {
+		t.Fatal("failed route still allowed")
+	}
+	if !main.allow() || !ws.allow() {
+		t.Fatal("a failing route rejects requests to the other upstreams")
+	}
+	if state := p.GetServiceBreaker(service.ID).State; state != BreakerClosed {
+		t.Fatalf("main breaker %s, want closed", state)
+	}
+
+	service.Routes = service.Routes[1:]
+	p.pruneRouteBreakers(service)
+	if _, ok := p.breakers[upstreamKey(service.ID, "/api")]; ok {
+		t.Fatal("removed route kept its breaker")
+	}
+	if _, ok := p.breakers[upstreamKey(service.ID, "/ws")]; !ok {
+		t.Fatal("remaining route lost its breaker")
+	}
+
+	p.dropBreakers(service.ID, nil)
+	if len(p.breakers) != 0 {
+		t.Fatalf("%d breakers left after dropping the service", len(p.breakers))
+	}
+
+	service.BreakerThreshold = 0
+	if cb := p.breakerFor(service, ""); cb != nil {
+		t.Fatal("breaker without a threshold")
+	}
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
+	"gorm.io/gorm"
+)
+
+const (
+	// caValidity is the lifetime of the edge certificate authority
+	caValidity = 10 * 365 * 24 * time.Hour
+	// leafValidity stays under the 398 day limit enforced by browsers
+	leafValidity = 397 * 24 * time.Hour
+	// leafRenewBefore is how long before expiry a leaf certificate is reissued
+	leafRenewBefore = 30 * 24 * time.Hour
+)
+
+// localCA is an edge-local certificate authority that issues server
+// certificates for tunnel listeners. The CA and issued leaves are persisted so
+// clients only have to trust the CA once.
+type localCA struct {
+	repo    *repositories.CertificateRepository
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+
+	leaves map[string]*tls.Certificate // host -> issued certificate
+	mu     sync.Mutex
+}
+
+// newLocalCA loads the edge CA from storage, generating it on first use
+func newLocalCA(repo *repositories.CertificateRepository, edgeID string) (*localCA, error) {
+	ca := &localCA{
+		repo:   repo,
+		leaves: make(map[string]*tls.Certificate),
+	}
+
+	stored, err := repo.GetCertificate(models.CertificateNameCA)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+
+	if stored == nil || time.Now().After(stored.NotAfter) {
+		if stored, err = generateCA(edgeID); err != nil {
+			return nil, fmt.Errorf("failed to generate CA certificate: %w", err)
+		}
+		if err := repo.SaveCertificate(stored); err != nil {
+			return nil, fmt.Errorf("failed to save CA certificate: %w", err)
+		}
+	}
+
+	keyPair, err := tls.X509KeyPair([]byte(stored.CertPEM), []byte(stored.KeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type")
+	}
+
+	ca.cert = keyPair.Leaf
+	ca.key = key
+	ca.certPEM = stored.CertPEM
+
+	return ca, nil
+}
+
+// CertificatePEM returns the PEM encoded CA certificate clients should trust
+func (ca *localCA) CertificatePEM() string {
+	return ca.certPEM
+}
+
+// certificateFor returns a server certificate for host (an IP address or DNS
+// name), issuing and persisting a new one when none is stored or it is about
+// to expire
+func (ca *localCA) certificateFor(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, exists := ca.leaves[host]; exists && !needsRenewal(cert.Leaf) {
+		return cert, nil
+	}
+
+	stored, err := ca.repo.GetCertificate(host)
+	if err == nil {
+		if cert, err := tls.X509KeyPair([]byte(stored.CertPEM), []byte(stored.KeyPEM)); err == nil &&
+			!needsRenewal(cert.Leaf) && cert.Leaf.CheckSignatureFrom(ca.cert) == nil {
+			ca.leaves[host] = &cert
+			return &cert, nil
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load certificate for %s: %w", host, err)
+	}
+
+	issued, err := ca.issue(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", host, err)
+	}
+	if err := ca.repo.SaveCertificate(issued); err != nil {
+		return nil, fmt.Errorf("failed to save certificate for %s: %w", host, err)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(issued.CertPEM), []byte(issued.KeyPEM))
+	if err != nil {
+		return nil, err
+	}
+	ca.leaves[host] = &cert
+
+	return &cert, nil
+}
+
+// issue signs a new server certificate for host
+func (ca *localCA) issue(host string) (*models.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeCertificate(host, der, key, template.NotAfter)
+}
+
+// generateCA creates a new self-signed certificate authority for the edge
+func generateCA(edgeID string) (*models.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("Arqut Edge Local CA (%s)", edgeID),
+			Organization: []string{"Arqut Edge"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeCertificate(models.CertificateNameCA, der, key, template.NotAfter)
+}
+
+// encodeCertificate PEM encodes a certificate and its key for storage
+func encodeCertificate(name string, der []byte, key *ecdsa.PrivateKey, notAfter time.Time) (*models.Certificate, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Certificate{
+		Name:     name,
+		CertPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		KeyPEM:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		NotAfter: notAfter,
+	}, nil
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// needsRenewal reports whether a certificate is missing or close to expiry
+func needsRenewal(cert *x509.Certificate) bool {
+	return cert == nil || time.Until(cert.NotAfter) < leafRenewBefore
+}
+
+// serverTLSConfig returns the TLS configuration for a tunnel listener bound to
+// ip, serving certificates issued by the edge CA. Clients asking for a server
+// name accepted by isKnownName get a certificate for that name, everyone else
+// gets one for the listener IP.
+func (p *ProxyProvider) serverTLSConfig(ip string, isKnownName func(string) bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+			if name != "" && isKnownName(name) {
+				return p.ca.certificateFor(name)
+			}
+			return p.ca.certificateFor(ip)
+		},
+	}
+}
@@ -0,0 +1,659 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// cacheFileExt is the extension of cache entry files. Each file holds the
+// JSON encoded entry on its first line followed by the response body.
+const cacheFileExt = ".cache"
+
+// cacheEntry is one cached response
+type cacheEntry struct {
+	Key      string      `json:"key"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+
+	path     string
+	offset   int64 // where the body starts in the file
+	size     int64 // body size
+	lastUsed time.Time
+}
+
+// revalidatable reports whether the upstream can confirm the entry with a
+// conditional request
+func (e *cacheEntry) revalidatable() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// responseCache is the disk cache of a service's upstream responses, evicting
+// the least recently used entries above its size cap
+type responseCache struct {
+	name     string
+	dir      string
+	maxSize  int64
+	entries  map[string]*cacheEntry
+	size     int64
+	disabled bool // set once the cache has been dropped, so late writes are discarded
+	logger   *logger.Logger
+	mu       sync.Mutex
+}
+
+// newResponseCache opens the cache in dir, loading the entries left by a
+// previous run
+func newResponseCache(name, dir string, log *logger.Logger) (*responseCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &responseCache{
+		name:    name,
+		dir:     dir,
+		entries: make(map[string]*cacheEntry),
+		logger:  log,
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		if file.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(file.Name(), cacheFileExt) {
+			// Left over from an interrupted write
+			os.Remove(path)
+			continue
+		}
+		entry, err := loadCacheEntry(path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		if old, exists := c.entries[entry.Key]; exists {
+			// Keep the newer copy of a response stored twice
+			if old.StoredAt.After(entry.StoredAt) {
+				os.Remove(path)
+				continue
+			}
+			c.size -= old.size
+			os.Remove(old.path)
+		}
+		c.entries[entry.Key] = entry
+		c.size += entry.size
+	}
+
+	return c, nil
+}
+
+// loadCacheEntry reads the entry line of a cache file
+func loadCacheEntry(path string) (*cacheEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, err
+	}
+	entry.path = path
+	entry.offset = int64(len(line))
+	entry.size = info.Size() - entry.offset
+	entry.lastUsed = entry.StoredAt
+	return entry, nil
+}
+
+// setMaxSize changes the size cap, evicting entries above it
+func (c *responseCache) setMaxSize(sizeMB int) {
+	if sizeMB <= 0 {
+		sizeMB = models.DefaultCacheSizeMB
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = int64(sizeMB) << 20
+	c.evict()
+}
+
+// maxEntrySize is the largest body cached, so one response can't flush
+// everything else
+func (c *responseCache) maxEntrySize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxSize / 4
+}
+
+// get returns the entry for a key, or nil
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if exists {
+		entry.lastUsed = time.Now()
+	}
+	return entry
+}
+
+// put adds an entry whose file has been written, replacing any previous entry
+// for its key
+func (c *responseCache) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disabled {
+		os.Remove(entry.path)
+		return
+	}
+	if old, exists := c.entries[entry.Key]; exists {
+		c.remove(old)
+	}
+	entry.lastUsed = time.Now()
+	c.entries[entry.Key] = entry
+	c.size += entry.size
+	c.evict()
+}
+
+// fresh reports whether an entry can be served without revalidation. The
+// expiry is read under c.mu as refresh may move it.
+func (c *responseCache) fresh(entry *cacheEntry, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Before(entry.Expires)
+}
+
+// refresh extends an entry after the upstream confirmed it is unchanged
+func (c *responseCache) refresh(entry *cacheEntry, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.Expires = expires
+}
+
+// evict removes least recently used entries until the cache fits under its
+// cap. The caller must hold c.mu.
+func (c *responseCache) evict() {
+	for c.size > c.maxSize && len(c.entries) > 0 {
+		var oldest *cacheEntry
+		for _, entry := range c.entries {
+			if oldest == nil || entry.lastUsed.Before(oldest.lastUsed) {
+				oldest = entry
+			}
+		}
+		c.remove(oldest)
+	}
+}
+
+// remove deletes an entry and its file. The caller must hold c.mu.
+func (c *responseCache) remove(entry *cacheEntry) {
+	delete(c.entries, entry.Key)
+	c.size -= entry.size
+	os.Remove(entry.path)
+}
+
+// purge removes every entry, returning how many entries and bytes were freed
+func (c *responseCache) purge() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count, size := len(c.entries), c.size
+	for _, entry := range c.entries {
+		c.remove(entry)
+	}
+	return count, size
+}
+
+// drop purges the cache and deletes its directory
+func (c *responseCache) drop() {
+	c.purge()
+
+	c.mu.Lock()
+	c.disabled = true
+	c.mu.Unlock()
+
+	os.RemoveAll(c.dir)
+}
+
+// cacheKey identifies a response by URL and the encoding the client gets, as
+// compressed and plain variants are stored separately
+func cacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI() + "\n" + negotiateEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+// cacheControl parses a Cache-Control header into lowercase directives
+func cacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// requestCacheable reports whether a request may be answered from the cache
+func requestCacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	_, noStore := cacheControl(r.Header.Get("Cache-Control"))["no-store"]
+	return !noStore
+}
+
+// responseExpiry decides whether a response may be stored and until when it
+// is fresh. Responses without a lifetime are stored only if they can be
+// revalidated.
+func responseExpiry(r *http.Request, status int, header http.Header, now time.Time) (time.Time, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" || header.Get("Content-Range") != "" {
+		return time.Time{}, false
+	}
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return time.Time{}, false
+			}
+		}
+	}
+
+	cc := cacheControl(header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := cc["private"]; ok {
+		return time.Time{}, false
+	}
+	_, public := cc["public"]
+	_, shared := cc["s-maxage"]
+	if r.Header.Get("Authorization") != "" && !public && !shared {
+		// Responses to authenticated requests are per user unless marked otherwise
+		return time.Time{}, false
+	}
+
+	var lifetime time.Duration
+	if _, noCache := cc["no-cache"]; !noCache {
+		if v, ok := cc["s-maxage"]; ok {
+			lifetime = parseSeconds(v)
+		} else if v, ok := cc["max-age"]; ok {
+			lifetime = parseSeconds(v)
+		} else if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			lifetime = expires.Sub(date)
+		}
+		lifetime -= parseSeconds(header.Get("Age"))
+	}
+
+	if lifetime <= 0 {
+		if header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+			return time.Time{}, false
+		}
+		return now, true
+	}
+	return now.Add(lifetime), true
+}
+
+// parseSeconds parses a delta-seconds value, returning 0 if invalid
+func parseSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// etagMatches reports whether an If-None-Match header matches an ETag, using
+// weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter passes a response through to the client while writing it to a
+// cache file. When revalidating, a 304 from the upstream is held back so the
+// cached entry can be served instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	cache        *responseCache
+	req          *http.Request
+	revalidating *cacheEntry
+
+	status      int
+	notModified bool
+	file        *os.File
+	entry       *cacheEntry
+	written     int64
+	limit       int64
+}
+
+// WriteHeader implements http.ResponseWriter
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status != 0 || status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	if cw.revalidating != nil && status == http.StatusNotModified {
+		cw.notModified = true
+		return
+	}
+
+	cw.start(status)
+	cw.Header().Set("X-Cache", "MISS")
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// start opens the cache file when the response may be stored
+func (cw *cacheWriter) start(status int) {
+	now := time.Now()
+	expires, ok := responseExpiry(cw.req, status, cw.Header(), now)
+	if !ok {
+		return
+	}
+	if length := cw.Header().Get("Content-Length"); length != "" {
+		if n, err := strconv.ParseInt(length, 10, 64); err == nil && n > cw.limit {
+			return
+		}
+	}
+
+	entry := &cacheEntry{
+		Key:      cacheKey(cw.req),
+		Status:   status,
+		Header:   cw.Header().Clone(),
+		StoredAt: now,
+		Expires:  expires,
+	}
+	entry.Header.Del("X-Cache")
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	file, err := os.CreateTemp(cw.cache.dir, "tmp-*")
+	if err != nil {
+		cw.cache.logger.Printf("[Proxy] %s -> failed to create cache file: %v", cw.cache.name, err)
+		return
+	}
+	line = append(line, '\n')
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+
+	entry.offset = int64(len(line))
+	cw.file = file
+	cw.entry = entry
+}
+
+// Write implements http.ResponseWriter
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.notModified {
+		return len(b), nil
+	}
+
+	n, err := cw.ResponseWriter.Write(b)
+	if cw.file != nil {
+		cw.written += int64(n)
+		if cw.written > cw.limit {
+			cw.abort()
+		} else if _, ferr := cw.file.Write(b[:n]); ferr != nil {
+			cw.abort()
+		}
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// abort discards the cache file being written
+func (cw *cacheWriter) abort() {
+	if cw.file == nil {
+		return
+	}
+	cw.file.Close()
+	os.Remove(cw.file.Name())
+	cw.file = nil
+}
+
+// finish stores the written response if it was received completely
+func (cw *cacheWriter) finish() {
+	if cw.file == nil {
+		return
+	}
+	if length := cw.Header().Get("Content-Length"); length != "" && length != strconv.FormatInt(cw.written, 10) {
+		// The upstream or the client went away mid-response
+		cw.abort()
+		return
+	}
+
+	tmp := cw.file.Name()
+	if err := cw.file.Close(); err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	// Every stored copy gets its own file so readers of a replaced entry
+	// keep reading the file they looked up
+	sum := sha256.Sum256([]byte(cw.entry.Key))
+	name := hex.EncodeToString(sum[:16]) + "-" + strconv.FormatInt(cw.entry.StoredAt.UnixNano(), 36) + cacheFileExt
+	cw.entry.path = filepath.Join(cw.cache.dir, name)
+	cw.entry.size = cw.written
+	if err := os.Rename(tmp, cw.entry.path); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	cw.cache.put(cw.entry)
+}
+
+// serveCached writes a cached entry, answering the client's own conditional
+// request when its ETag matches
+func serveCached(w http.ResponseWriter, r *http.Request, entry *cacheEntry) bool {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		// Evicted since the lookup
+		return false
+	}
+	defer file.Close()
+	if _, err := file.Seek(entry.offset, io.SeekStart); err != nil {
+		return false
+	}
+
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	header.Set("X-Cache", "HIT")
+
+	if etagMatches(r.Header.Get("If-None-Match"), entry.Header.Get("ETag")) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(entry.size, 10))
+	w.WriteHeader(entry.Status)
+	_, _ = io.CopyN(w, file, entry.size)
+	return true
+}
+
+// cacheResponses serves a service's cacheable GET requests from its disk
+// cache, storing upstream responses that Cache-Control allows
+func (p *ProxyProvider) cacheResponses(service *models.ProxyService, next http.Handler) http.Handler {
+	c := p.cacheFor(service)
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requestCacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		entry := c.get(key)
+		_, noCache := cacheControl(r.Header.Get("Cache-Control"))["no-cache"]
+		if entry != nil && c.fresh(entry, time.Now()) && !noCache && serveCached(w, r, entry) {
+			return
+		}
+
+		clientConditional := r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+		if clientConditional && entry == nil {
+			// The answer is likely a 304 there is nothing to store from
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		upstreamReq := r
+		cw := &cacheWriter{ResponseWriter: w, cache: c, req: r, limit: c.maxEntrySize()}
+		if entry != nil && entry.revalidatable() {
+			cw.revalidating = entry
+			upstreamReq = r.Clone(r.Context())
+			upstreamReq.Header.Del("If-None-Match")
+			upstreamReq.Header.Del("If-Modified-Since")
+			if etag := entry.Header.Get("ETag"); etag != "" {
+				upstreamReq.Header.Set("If-None-Match", etag)
+			}
+			if modified := entry.Header.Get("Last-Modified"); modified != "" {
+				upstreamReq.Header.Set("If-Modified-Since", modified)
+			}
+		}
+
+		next.ServeHTTP(cw, upstreamReq)
+
+		if cw.notModified {
+			expires, ok := responseExpiry(r, http.StatusOK, mergeHeaders(entry.Header, cw.Header()), time.Now())
+			if !ok {
+				expires = time.Now()
+			}
+			c.refresh(entry, expires)
+			// Drop the 304's headers before writing the cached ones
+			for name := range w.Header() {
+				w.Header().Del(name)
+			}
+			if !serveCached(w, r, entry) {
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			}
+			return
+		}
+		cw.finish()
+	})
+}
+
+// mergeHeaders returns base updated with the fields of update, as a 304
+// updates the stored response's headers
+func mergeHeaders(base, update http.Header) http.Header {
+	merged := base.Clone()
+	for name, values := range update {
+		merged[name] = values
+	}
+	return merged
+}
+
+// cacheFor returns the disk cache of a service, creating or loading it on
+// first use, or nil if caching is off. Turning caching off deletes the cache.
+func (p *ProxyProvider) cacheFor(service *models.ProxyService) *responseCache {
+	if !service.CacheEnabled {
+		p.dropCache(service.ID)
+		return nil
+	}
+
+	p.mu.RLock()
+	c, exists := p.caches[service.ID]
+	p.mu.RUnlock()
+
+	if !exists {
+		// Load the cache directory without holding the provider lock
+		opened, err := newResponseCache(service.Name, filepath.Join(p.cacheDir, service.ID), p.logger)
+		if err != nil {
+			p.logger.Printf("Failed to open response cache for service %s: %v", service.Name, err)
+			return nil
+		}
+
+		p.mu.Lock()
+		if c, exists = p.caches[service.ID]; !exists {
+			c = opened
+			p.caches[service.ID] = c
+		}
+		p.mu.Unlock()
+	}
+	c.setMaxSize(service.CacheSizeMB)
+	return c
+}
+
+// PurgeCache removes every cached response of a service, returning how many
+// entries and bytes were freed
+func (p *ProxyProvider) PurgeCache(id string) (int, int64) {
+	p.mu.RLock()
+	c, exists := p.caches[id]
+	p.mu.RUnlock()
+
+	if !exists {
+		return 0, 0
+	}
+	return c.purge()
+}
+
+// dropCache deletes the cache of a removed service
+func (p *ProxyProvider) dropCache(id string) {
+	p.mu.Lock()
+	c, exists := p.caches[id]
+	delete(p.caches, id)
+	p.mu.Unlock()
+
+	if exists {
+		c.drop()
+	}
+}
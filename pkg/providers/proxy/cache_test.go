@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+func TestResponseExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tests := []struct {
+		name          string
+		status        int
+		header        http.Header
+		authorization bool
+		want          time.Duration // lifetime from now
+		wantOK        bool
+	}{
+		{name: "max-age", header: http.Header{"Cache-Control": {"max-age=60"}}, want: time.Minute, wantOK: true},
+		{name: "s-maxage wins", header: http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, want: 2 * time.Minute, wantOK: true},
+		{name: "age is subtracted", header: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, want: 40 * time.Second, wantOK: true},
+		{
+			name:   "expires",
+			header: http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}},
+			want:   time.Hour, wantOK: true,
+		},
+		{name: "no lifetime", header: http.Header{}, wantOK: false},
+		{name: "no lifetime but revalidatable", header: http.Header{"Etag": {`"v1"`}}, want: 0, wantOK: true},
+		{name: "no-cache is stored stale", header: http.Header{"Cache-Control": {"no-cache, max-age=60"}, "Last-Modified": {date}}, want: 0, wantOK: true},
+		{name: "expired", header: http.Header{"Cache-Control": {"max-age=10"}, "Age": {"30"}}, wantOK: false},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store, max-age=60"}}, wantOK: false},
+		{name: "private", header: http.Header{"Cache-Control": {"private, max-age=60"}}, wantOK: false},
+		{name: "set-cookie", header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, wantOK: false},
+		{name: "not 200", status: http.StatusNotFound, header: http.Header{"Cache-Control": {"max-age=60"}}, wantOK: false},
+		{name: "partial", header: http.Header{"Cache-Control": {"max-age=60"}, "Content-Range": {"bytes 0-9/100"}}, wantOK: false},
+		{name: "vary accept-encoding", header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-encoding"}}, want: time.Minute, wantOK: true},
+		{name: "vary on other headers", header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Encoding, Cookie"}}, wantOK: false},
+		{name: "authorized", header: http.Header{"Cache-Control": {"max-age=60"}}, authorization: true, wantOK: false},
+		{name: "authorized public", header: http.Header{"Cache-Control": {"public, max-age=60"}}, authorization: true, want: time.Minute, wantOK: true},
+		{name: "invalid max-age", header: http.Header{"Cache-Control": {"max-age=soon"}}, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			expires, ok := responseExpiry(req, status, tt.header, now)
+			if ok != tt.wantOK {
+				t.Fatalf("storable = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !expires.Equal(now.Add(tt.want)) {
+				t.Fatalf("expires after %s, want %s", expires.Sub(now), tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	request := func(target, acceptEncoding string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name string
+		a, b *http.Request
+		same bool
+	}{
+		{name: "same request", a: request("http://a.example/x", "gzip"), b: request("http://a.example/x", "gzip"), same: true},
+		{name: "same negotiated encoding", a: request("http://a.example/x", "gzip, deflate"), b: request("http://a.example/x", "gzip;q=0.9"), same: true},
+		{name: "encoding", a: request("http://a.example/x", "gzip"), b: request("http://a.example/x", "br"), same: false},
+		{name: "plain and compressed", a: request("http://a.example/x", ""), b: request("http://a.example/x", "gzip"), same: false},
+		{name: "host", a: request("http://a.example/x", ""), b: request("http://b.example/x", ""), same: false},
+		{name: "path", a: request("http://a.example/x", ""), b: request("http://a.example/y", ""), same: false},
+		{name: "query", a: request("http://a.example/x?v=1", ""), b: request("http://a.example/x?v=2", ""), same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := cacheKey(tt.a) == cacheKey(tt.b); same != tt.same {
+				t.Fatalf("same key = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestRequestCacheable(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   bool
+	}{
+		{name: "get", method: http.MethodGet, want: true},
+		{name: "post", method: http.MethodPost, want: false},
+		{name: "range", method: http.MethodGet, header: http.Header{"Range": {"bytes=0-9"}}, want: false},
+		{name: "upgrade", method: http.MethodGet, header: http.Header{"Upgrade": {"websocket"}}, want: false},
+		{name: "no-store", method: http.MethodGet, header: http.Header{"Cache-Control": {"no-store"}}, want: false},
+		{name: "no-cache", method: http.MethodGet, header: http.Header{"Cache-Control": {"no-cache"}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			if got := requestCacheable(r); got != tt.want {
+				t.Fatalf("requestCacheable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch, etag string
+		want              bool
+	}{
+		{ifNoneMatch: `"v1"`, etag: `"v1"`, want: true},
+		{ifNoneMatch: `W/"v1"`, etag: `"v1"`, want: true},
+		{ifNoneMatch: `"v0", "v1"`, etag: `W/"v1"`, want: true},
+		{ifNoneMatch: `*`, etag: `"v1"`, want: true},
+		{ifNoneMatch: `"v2"`, etag: `"v1"`, want: false},
+		{ifNoneMatch: `"v1"`, etag: ``, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestCacheResponses(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	p.cacheDir = t.TempDir()
+
+	var hits, revalidations int
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("cached body"))
+	})
+	service := &models.ProxyService{ID: "svc", Name: "test", CacheEnabled: true}
+	handler := p.cacheResponses(service, upstream)
+
+	steps := []struct {
+		name             string
+		ifNoneMatch      string
+		wantStatus       int
+		wantCache        string
+		wantHits         int
+		wantRevalidation int
+	}{
+		{name: "miss", wantStatus: http.StatusOK, wantCache: "MISS", wantHits: 1},
+		{name: "stale entry revalidated", wantStatus: http.StatusOK, wantCache: "HIT", wantHits: 2, wantRevalidation: 1},
+		{name: "client conditional", ifNoneMatch: `"v1"`, wantStatus: http.StatusNotModified, wantCache: "HIT", wantHits: 3, wantRevalidation: 2},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/page", nil)
+		if step.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", step.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantStatus || rec.Header().Get("X-Cache") != step.wantCache {
+			t.Fatalf("%s: response = %d %s, want %d %s", step.name, rec.Code, rec.Header().Get("X-Cache"), step.wantStatus, step.wantCache)
+		}
+		if step.wantStatus == http.StatusOK && rec.Body.String() != "cached body" {
+			t.Fatalf("%s: body = %q", step.name, rec.Body.String())
+		}
+		if hits != step.wantHits || revalidations != step.wantRevalidation {
+			t.Fatalf("%s: upstream hits = %d, revalidations = %d; want %d, %d", step.name, hits, revalidations, step.wantHits, step.wantRevalidation)
+		}
+	}
+
+	if entries, size := p.PurgeCache("svc"); entries != 1 || size != int64(len("cached body")) {
+		t.Fatalf("purged %d entries of %d bytes, want 1 of %d", entries, size, len("cached body"))
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := newResponseCache("test", t.TempDir(), newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setMaxSize(1)
+
+	// Entries of 400 KiB: the third pushes the cache over 1 MiB
+	for i := range 3 {
+		c.put(&cacheEntry{Key: strconv.Itoa(i), size: 400 << 10, path: "missing"})
+		time.Sleep(time.Millisecond)
+	}
+	if c.get("0") != nil {
+		t.Fatal("least recently used entry kept")
+	}
+	if c.get("1") == nil || c.get("2") == nil {
+		t.Fatal("recent entries evicted")
+	}
+}
@@ -0,0 +1,404 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// captureBodyLimit is the most bytes of each body kept by the inspector
+	captureBodyLimit = 64 << 10
+	// captureReplayTimeout bounds a replayed request
+	captureReplayTimeout = time.Minute
+)
+
+// CapturedBody is a request or response body kept by the inspector
+type CapturedBody struct {
+	Data      string `json:"data"`
+	Encoding  string `json:"encoding,omitempty"` // "base64" when the body is not valid UTF-8
+	Size      int64  `json:"size"`               // full size on the wire, which may exceed the captured data
+	Truncated bool   `json:"truncated,omitempty"`
+	Decoded   string `json:"decoded,omitempty"` // content encoding the data was decoded from
+
+	raw []byte
+}
+
+// CapturedRequest is one request and response pair kept by the inspector
+type CapturedRequest struct {
+	ID              string       `json:"id"`
+	Time            time.Time    `json:"time"`
+	ClientIP        string       `json:"client_ip"`
+	Method          string       `json:"method"`
+	Host            string       `json:"host"`
+	URI             string       `json:"uri"`
+	RequestHeaders  http.Header  `json:"request_headers"`
+	RequestBody     CapturedBody `json:"request_body"`
+	Status          int          `json:"status"`
+	ResponseHeaders http.Header  `json:"response_headers"`
+	ResponseBody    CapturedBody `json:"response_body"`
+	DurationMs      float64      `json:"duration_ms"`
+	ReplayOf        string       `json:"replay_of,omitempty"`
+
+	remoteAddr string
+}
+
+// bodyCapture keeps the first captureBodyLimit bytes written to it
+type bodyCapture struct {
+	data []byte
+	size int64
+}
+
+// write records p, keeping it only while under the limit
+func (c *bodyCapture) write(p []byte) {
+	c.size += int64(len(p))
+	if room := captureBodyLimit - len(c.data); room > 0 {
+		c.data = append(c.data, p[:min(room, len(p))]...)
+	}
+}
+
+// body returns the captured body for the API
+func (c *bodyCapture) body() CapturedBody {
+	b := CapturedBody{
+		Size:      c.size,
+		Truncated: c.size > int64(len(c.data)),
+		raw:       c.data,
+	}
+	if utf8.Valid(c.data) {
+		b.Data = string(c.data)
+	} else {
+		b.Data = base64.StdEncoding.EncodeToString(c.data)
+		b.Encoding = "base64"
+	}
+	return b
+}
+
+// decode replaces a gzip or brotli encoded capture with the decoded body, so
+// compressed responses are readable in the inspector. A capture cut off at
+// the limit decodes as far as it goes; anything that fails to decode is kept
+// as captured.
+func (c *bodyCapture) decode(contentEncoding string) CapturedBody {
+	b := c.body()
+
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case encodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(c.data))
+		if err != nil {
+			return b
+		}
+		r = zr
+	case encodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(c.data))
+	default:
+		return b
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, captureBodyLimit+1))
+	if err != nil && len(data) == 0 {
+		return b
+	}
+	if len(data) > captureBodyLimit {
+		data = data[:captureBodyLimit]
+		b.Truncated = true
+	}
+
+	decoded := bodyCapture{data: data, size: c.size}
+	out := decoded.body()
+	out.Truncated = b.Truncated
+	out.Decoded = strings.ToLower(strings.TrimSpace(contentEncoding))
+	return out
+}
+
+// captureReader copies a request body into a bodyCapture as it is read
+type captureReader struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+// Read implements io.Reader
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.write(p[:n])
+	return n, err
+}
+
+// captureWriter copies the status and body of a response into a capture
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bodyCapture
+}
+
+// WriteHeader implements http.ResponseWriter
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 || cw.status < 200 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(b)
+	cw.body.write(b[:n])
+	return n, err
+}
+
+// Hijack records a protocol switch before handing over the connection
+func (cw *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// replayWriter is the response writer of a replayed request. The capture
+// keeps the response, so the body is discarded.
+type replayWriter struct {
+	header http.Header
+}
+
+// Header implements http.ResponseWriter
+func (w *replayWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter
+func (w *replayWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *replayWriter) WriteHeader(int) {}
+
+// captureBuffer is the request inspector of a service: a ring buffer of the
+// last requests that reached its upstreams
+type captureBuffer struct {
+	entries []*CapturedRequest
+	next    int // slot the next capture is written to
+	seq     uint64
+	handler http.Handler // upstream side of the service, used for replays
+	mu      sync.RWMutex
+}
+
+// configure sets the buffer size, keeping the newest captures, and the
+// handler requests are passed to
+func (b *captureBuffer) configure(size int, handler http.Handler) {
+	if size <= 0 {
+		size = models.DefaultCaptureSize
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handler = handler
+	if size == len(b.entries) {
+		return
+	}
+
+	kept := b.newestFirst()
+	if len(kept) > size {
+		kept = kept[:size]
+	}
+	b.entries = make([]*CapturedRequest, size)
+	b.next = 0
+	for i := len(kept) - 1; i >= 0; i-- {
+		b.entries[b.next] = kept[i]
+		b.next = (b.next + 1) % size
+	}
+}
+
+// add stores a capture, overwriting the oldest once the buffer is full
+func (b *captureBuffer) add(capture *CapturedRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	capture.ID = strconv.FormatUint(b.seq, 10)
+	b.entries[b.next] = capture
+	b.next = (b.next + 1) % len(b.entries)
+}
+
+// newestFirst returns the captures from newest to oldest. The caller must
+// hold b.mu.
+func (b *captureBuffer) newestFirst() []*CapturedRequest {
+	captures := make([]*CapturedRequest, 0, len(b.entries))
+	for i := 1; i <= len(b.entries); i++ {
+		if capture := b.entries[(b.next-i+len(b.entries))%len(b.entries)]; capture != nil {
+			captures = append(captures, capture)
+		}
+	}
+	return captures
+}
+
+// list returns the captures from newest to oldest
+func (b *captureBuffer) list() []*CapturedRequest {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.newestFirst()
+}
+
+// get returns a capture by ID, or nil if it has been overwritten
+func (b *captureBuffer) get(id string) *CapturedRequest {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, capture := range b.entries {
+		if capture != nil && capture.ID == id {
+			return capture
+		}
+	}
+	return nil
+}
+
+// serve passes a request to the upstream side of the service and captures it
+func (b *captureBuffer) serve(w http.ResponseWriter, r *http.Request, replayOf string) *CapturedRequest {
+	b.mu.RLock()
+	handler := b.handler
+	b.mu.RUnlock()
+
+	capture := &CapturedRequest{
+		Time:           time.Now(),
+		ClientIP:       clientIP(r.RemoteAddr),
+		Method:         r.Method,
+		Host:           r.Host,
+		URI:            r.URL.RequestURI(),
+		RequestHeaders: r.Header.Clone(),
+		ReplayOf:       replayOf,
+		remoteAddr:     r.RemoteAddr,
+	}
+
+	var reqBody bodyCapture
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &captureReader{ReadCloser: r.Body, capture: &reqBody}
+	}
+	cw := &captureWriter{ResponseWriter: w}
+
+	handler.ServeHTTP(cw, r)
+
+	capture.DurationMs = float64(time.Since(capture.Time).Microseconds()) / 1000
+	capture.RequestBody = reqBody.body()
+	capture.Status = cw.status
+	if capture.Status == 0 {
+		capture.Status = http.StatusOK
+	}
+	capture.ResponseHeaders = w.Header().Clone()
+	capture.ResponseBody = cw.body.decode(capture.ResponseHeaders.Get("Content-Encoding"))
+
+	b.add(capture)
+	return capture
+}
+
+// replay sends a captured request to the upstream again, capturing the new
+// exchange
+func (b *captureBuffer) replay(original *CapturedRequest) (*CapturedRequest, error) {
+	if original.RequestBody.Truncated {
+		return nil, fmt.Errorf("request body was too large to capture and cannot be replayed")
+	}
+	if original.Status == http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("protocol upgrades cannot be replayed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), captureReplayTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, original.Method, original.URI, bytes.NewReader(original.RequestBody.raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Host = original.Host
+	req.Header = original.RequestHeaders.Clone()
+	req.RemoteAddr = original.remoteAddr
+	if len(original.RequestBody.raw) == 0 {
+		req.Body = http.NoBody
+	}
+
+	return b.serve(&replayWriter{header: make(http.Header)}, req, original.ID), nil
+}
+
+// capture keeps the last requests of a service in its inspector when
+// capturing is enabled, and drops the inspector when it is not
+func (p *ProxyProvider) capture(service *models.ProxyService, next http.Handler) http.Handler {
+	p.mu.Lock()
+	if !service.CaptureEnabled {
+		delete(p.captures, service.ID)
+		p.mu.Unlock()
+		return next
+	}
+	b, exists := p.captures[service.ID]
+	if !exists {
+		b = &captureBuffer{}
+		p.captures[service.ID] = b
+	}
+	p.mu.Unlock()
+
+	b.configure(service.CaptureSize, next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.serve(w, r, "")
+	})
+}
+
+// captureBufferFor returns the inspector of a service, or nil if capturing
+// is off
+func (p *ProxyProvider) captureBufferFor(id string) *captureBuffer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.captures[id]
+}
+
+// GetCaptures returns the captured requests of a service, newest first
+func (p *ProxyProvider) GetCaptures(id string) []*CapturedRequest {
+	b := p.captureBufferFor(id)
+	if b == nil {
+		return []*CapturedRequest{}
+	}
+	return b.list()
+}
+
+// ReplayCapture resends a captured request to the service's upstream and
+// returns the new capture. It returns nil without an error if the capture
+// does not exist.
+func (p *ProxyProvider) ReplayCapture(id, captureID string) (*CapturedRequest, error) {
+	b := p.captureBufferFor(id)
+	if b == nil {
+		return nil, nil
+	}
+	original := b.get(captureID)
+	if original == nil {
+		return nil, nil
+	}
+
+	p.mu.RLock()
+	_, running := p.handlers[id]
+	p.mu.RUnlock()
+	if !running {
+		return nil, fmt.Errorf("service is not running")
+	}
+
+	return b.replay(original)
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// gzipped returns data compressed with gzip
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// brotlied returns data compressed with brotli
+func brotlied(data []byte) []byte {
+	var buf bytes.Buffer
+	bw := brotli.NewWriter(&buf)
+	bw.Write(data)
+	bw.Close()
+	return buf.Bytes()
+}
+
+func TestBodyCaptureDecode(t *testing.T) {
+	text := []byte(strings.Repeat("hello inspector ", 64))
+	large := []byte(strings.Repeat("a", captureBodyLimit+100))
+	random := make([]byte, captureBodyLimit*2)
+	for i := range random {
+		random[i] = byte(rand.N(256))
+	}
+
+	tests := []struct {
+		name          string
+		wire          []byte
+		encoding      string
+		wantData      []byte // nil to skip
+		wantEncoding  string
+		wantDecoded   string
+		wantTruncated bool
+	}{
+		{name: "plain", wire: text, wantData: text},
+		{name: "binary", wire: []byte{0xff, 0xfe, 0x00}, wantEncoding: "base64"},
+		{name: "gzip", wire: gzipped(text), encoding: "gzip", wantData: text, wantDecoded: "gzip"},
+		{name: "brotli", wire: brotlied(text), encoding: " BR ", wantData: text, wantDecoded: "br"},
+		{name: "unknown encoding", wire: gzipped(text), encoding: "zstd", wantEncoding: "base64"},
+		{name: "corrupt gzip", wire: []byte("not gzip"), encoding: "gzip", wantData: []byte("not gzip")},
+		{name: "decodes past the limit", wire: gzipped(large), encoding: "gzip", wantData: large[:captureBodyLimit], wantDecoded: "gzip", wantTruncated: true},
+		{name: "truncated capture", wire: gzipped(random), encoding: "gzip", wantEncoding: "base64", wantDecoded: "gzip", wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c bodyCapture
+			for chunk := range slices.Chunk(tt.wire, 4096) {
+				c.write(chunk)
+			}
+
+			got := c.decode(tt.encoding)
+			if got.Size != int64(len(tt.wire)) {
+				t.Fatalf("size = %d, want the wire size %d", got.Size, len(tt.wire))
+			}
+			if got.Encoding != tt.wantEncoding || got.Decoded != tt.wantDecoded || got.Truncated != tt.wantTruncated {
+				t.Fatalf("encoding %q decoded %q truncated %v, want %q %q %v",
+					got.Encoding, got.Decoded, got.Truncated, tt.wantEncoding, tt.wantDecoded, tt.wantTruncated)
+			}
+			if tt.wantData != nil && got.Data != string(tt.wantData) {
+				t.Fatalf("data = %.40q..., want %.40q...", got.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestCaptureBufferRing(t *testing.T) {
+	b := &captureBuffer{}
+	b.configure(3, nil)
+	for range 5 {
+		b.add(&CapturedRequest{})
+	}
+
+	ids := func() []string {
+		var ids []string
+		for _, capture := range b.list() {
+			ids = append(ids, capture.ID)
+		}
+		return ids
+	}
+	if got := strings.Join(ids(), ","); got != "5,4,3" {
+		t.Fatalf("captures %s, want 5,4,3", got)
+	}
+	if b.get("2") != nil || b.get("4") == nil {
+		t.Fatal("get returned an overwritten capture or missed a kept one")
+	}
+
+	b.configure(2, nil)
+	if got := strings.Join(ids(), ","); got != "5,4" {
+		t.Fatalf("after shrinking: captures %s, want 5,4", got)
+	}
+	b.configure(4, nil)
+	b.add(&CapturedRequest{})
+	if got := strings.Join(ids(), ","); got != "6,5,4" {
+		t.Fatalf("after growing: captures %s, want 6,5,4", got)
+	}
+}
+
+func TestCaptureServeAndReplay(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{ID: "svc", CaptureEnabled: true, CaptureSize: 10}
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(gzipped(append([]byte("echo "), body...)))
+	})
+	handler := p.capture(service, upstream)
+
+	req := httptest.NewRequest(http.MethodPost, "http://app.example.com/items?x=1", strings.NewReader("payload"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	captures := p.GetCaptures(service.ID)
+	if len(captures) != 1 {
+		t.Fatalf("%d captures, want 1", len(captures))
+	}
+	capture := captures[0]
+	if capture.Method != http.MethodPost || capture.URI != "/items?x=1" || capture.Status != http.StatusCreated {
+		t.Fatalf("captured %s %s -> %d", capture.Method, capture.URI, capture.Status)
+	}
+	if capture.RequestBody.Data != "payload" {
+		t.Fatalf("request body %q, want payload", capture.RequestBody.Data)
+	}
+	if capture.ResponseBody.Data != "echo payload" || capture.ResponseBody.Decoded != "gzip" {
+		t.Fatalf("response body %q decoded from %q", capture.ResponseBody.Data, capture.ResponseBody.Decoded)
+	}
+
+	replayed := p.captureBufferFor(service.ID)
+	replay, err := replayed.replay(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ReplayOf != capture.ID || replay.ResponseBody.Data != "echo payload" {
+		t.Fatalf("replay of %q answered %q", replay.ReplayOf, replay.ResponseBody.Data)
+	}
+
+	service.CaptureEnabled = false
+	p.capture(service, upstream)
+	if got := p.GetCaptures(service.ID); len(got) != 0 {
+		t.Fatalf("%d captures after disabling capturing", len(got))
+	}
+}
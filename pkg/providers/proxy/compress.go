@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// defaultCompressionMinSize is the smallest response compressed by default
+	defaultCompressionMinSize = 1024
+	// brotliLevel trades ratio for CPU on small edge devices
+	brotliLevel = 4
+
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// defaultCompressionTypes are the MIME types compressed when a service does
+// not list its own
+var defaultCompressionTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// responseCompression compresses upstream responses of a service for clients
+// that accept it
+type responseCompression struct {
+	types   []string
+	minSize int64
+	flush   bool // flush after every upstream read, for streaming services
+}
+
+// newResponseCompression builds the compression settings of a service, or
+// returns nil if compression is off
+func newResponseCompression(service *models.ProxyService) *responseCompression {
+	if !service.Compression {
+		return nil
+	}
+
+	c := &responseCompression{
+		types:   defaultCompressionTypes,
+		minSize: defaultCompressionMinSize,
+		flush:   resolveTimeouts(service).flushInterval < 0,
+	}
+	if service.CompressionTypes != "" {
+		c.types = strings.Split(service.CompressionTypes, ",")
+	}
+	if service.CompressionMinSize > 0 {
+		c.minSize = int64(service.CompressionMinSize)
+	}
+	return c
+}
+
+// apply compresses resp in place when the client accepts an encoding and the
+// response qualifies. A nil compression does nothing.
+func (c *responseCompression) apply(resp *http.Response) {
+	if c == nil || !c.compressible(resp) {
+		return
+	}
+
+	encoding := negotiateEncoding(resp.Request.Header.Get("Accept-Encoding"))
+	// Caches must key on Accept-Encoding whether or not this client gets a
+	// compressed response
+	resp.Header.Add("Vary", "Accept-Encoding")
+	if encoding == "" {
+		return
+	}
+
+	if resp.ContentLength < 0 && !c.flush {
+		// Unknown length: look ahead so small chunked responses are left
+		// alone. Streaming services skip this rather than wait for data.
+		br := bufio.NewReaderSize(resp.Body, int(c.minSize))
+		peeked, _ := br.Peek(int(c.minSize))
+		resp.Body = readCloser{Reader: br, Closer: resp.Body}
+		if int64(len(peeked)) < c.minSize {
+			return
+		}
+	}
+
+	resp.Body = compressBody(resp.Body, encoding, c.flush)
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = false
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed body is no longer byte-identical to the tagged one
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+}
+
+// compressible reports whether a response may be compressed regardless of
+// what the client accepts
+func (c *responseCompression) compressible(resp *http.Response) bool {
+	switch {
+	case resp.Request == nil, resp.Request.Method == http.MethodHead:
+		return false
+	case resp.StatusCode < http.StatusOK, resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified, resp.StatusCode == http.StatusPartialContent:
+		// Covers websocket upgrades and other protocol switches
+		return false
+	case resp.Header.Get("Content-Encoding") != "", resp.Header.Get("Content-Range") != "":
+		return false
+	case strings.Contains(resp.Header.Get("Cache-Control"), "no-transform"):
+		return false
+	case resp.ContentLength >= 0 && resp.ContentLength < c.minSize:
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return matchMIMEType(c.types, mediaType)
+}
+
+// matchMIMEType reports whether mediaType is in types, where "text/*" matches
+// every text type
+func matchMIMEType(types []string, mediaType string) bool {
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+		if major, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mediaType, major+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks brotli or gzip from an Accept-Encoding header,
+// returning "" if the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	accepted := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			accepted[name] = q
+		}
+	}
+
+	// Brotli first so it wins ties
+	for _, encoding := range []string{encodingBrotli, encodingGzip} {
+		q, listed := accepted[encoding]
+		if !listed {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressBody returns a body streaming the compressed content of body
+func compressBody(body io.ReadCloser, encoding string, flush bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer body.Close()
+
+		var zw interface {
+			io.WriteCloser
+			Flush() error
+		}
+		if encoding == encodingBrotli {
+			zw = brotli.NewWriterLevel(pw, brotliLevel)
+		} else {
+			zw = gzip.NewWriter(pw)
+		}
+
+		buf := make([]byte, 32<<10)
+		var err error
+		for err == nil {
+			var n int
+			n, err = body.Read(buf)
+			if n > 0 {
+				if _, werr := zw.Write(buf[:n]); werr != nil {
+					err = werr
+					break
+				}
+				if flush {
+					if ferr := zw.Flush(); ferr != nil {
+						err = ferr
+						break
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// readCloser pairs a reader with the closer of the body it reads from
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "gzip", want: encodingGzip},
+		{acceptEncoding: "gzip, deflate, br", want: encodingBrotli},
+		{acceptEncoding: "GZIP", want: encodingGzip},
+		{acceptEncoding: "br;q=0.5, gzip;q=0.8", want: encodingGzip},
+		{acceptEncoding: "br;q=0.8, gzip;q=0.8", want: encodingBrotli},
+		{acceptEncoding: "gzip;q=0, br;q=0", want: ""},
+		{acceptEncoding: "*", want: encodingBrotli},
+		{acceptEncoding: "*;q=0.5, br;q=0", want: encodingGzip},
+		{acceptEncoding: "gzip; q=0.9", want: encodingGzip},
+		{acceptEncoding: "gzip;q=bogus", want: encodingGzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+				t.Fatalf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestResponse returns an upstream response to a GET accepting gzip and
+// brotli
+func newTestResponse(status int, contentType, body string) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, "http://upstream/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	resp := &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	return resp
+}
+
+func TestResponseCompressible(t *testing.T) {
+	c := &responseCompression{types: defaultCompressionTypes, minSize: 10}
+	large := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name   string
+		modify func(resp *http.Response)
+		want   bool
+	}{
+		{name: "compressible", want: true},
+		{name: "parameters ignored", modify: func(r *http.Response) { r.Header.Set("Content-Type", "text/html; charset=utf-8") }, want: true},
+		{name: "unknown length", modify: func(r *http.Response) { r.ContentLength = -1 }, want: true},
+		{name: "too small", modify: func(r *http.Response) { r.ContentLength = 5 }, want: false},
+		{name: "image", modify: func(r *http.Response) { r.Header.Set("Content-Type", "image/png") }, want: false},
+		{name: "no content type", modify: func(r *http.Response) { r.Header.Del("Content-Type") }, want: false},
+		{name: "already encoded", modify: func(r *http.Response) { r.Header.Set("Content-Encoding", "gzip") }, want: false},
+		{name: "range", modify: func(r *http.Response) { r.Header.Set("Content-Range", "bytes 0-99/200") }, want: false},
+		{name: "no-transform", modify: func(r *http.Response) { r.Header.Set("Cache-Control", "public, no-transform") }, want: false},
+		{name: "not modified", modify: func(r *http.Response) { r.StatusCode = http.StatusNotModified }, want: false},
+		{name: "switching protocols", modify: func(r *http.Response) { r.StatusCode = http.StatusSwitchingProtocols }, want: false},
+		{name: "head", modify: func(r *http.Response) { r.Request.Method = http.MethodHead }, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newTestResponse(http.StatusOK, "text/html", large)
+			if tt.modify != nil {
+				tt.modify(resp)
+			}
+			if got := c.compressible(resp); got != tt.want {
+				t.Fatalf("compressible = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCompressionApply(t *testing.T) {
+	body := strings.Repeat("hello compression ", 200)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "brotli", acceptEncoding: "gzip, br", wantEncoding: encodingBrotli},
+		{name: "gzip", acceptEncoding: "gzip", wantEncoding: encodingGzip},
+		{name: "identity", acceptEncoding: "identity", wantEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &responseCompression{types: defaultCompressionTypes, minSize: defaultCompressionMinSize}
+			resp := newTestResponse(http.StatusOK, "application/json", body)
+			resp.Request.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp.Header.Set("ETag", `"v1"`)
+			c.apply(resp)
+
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Fatalf("Vary = %q, want Accept-Encoding", got)
+			}
+
+			var r io.Reader = resp.Body
+			wantETag := `"v1"`
+			switch tt.wantEncoding {
+			case encodingGzip:
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = zr
+				wantETag = `W/"v1"`
+			case encodingBrotli:
+				r = brotli.NewReader(resp.Body)
+				wantETag = `W/"v1"`
+			}
+			decoded, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Fatal("decoded body differs from the upstream body")
+			}
+			if got := resp.Header.Get("ETag"); got != wantETag {
+				t.Fatalf("ETag = %q, want %q", got, wantETag)
+			}
+		})
+	}
+}
+
+func TestResponseCompressionSkipsSmallChunkedBodies(t *testing.T) {
+	c := &responseCompression{types: defaultCompressionTypes, minSize: 64}
+	resp := newTestResponse(http.StatusOK, "text/plain", "short")
+	resp.ContentLength = -1
+	c.apply(resp)
+
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none", got)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "short" {
+		t.Fatalf("body = %q, want %q", body, "short")
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// defaultErrorPage is the built-in page for 502, 503 and 504 responses
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Service}} - {{.StatusText}}</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+main { background: #fff; padding: 2em; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,.1); max-width: 420px; }
+h1 { font-size: 1.2em; margin-top: 0; }
+p { color: #555; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Service}}</h1>
+{{if eq .Status 503}}<p>This service is temporarily unavailable. Please try again later.</p>
+{{else if eq .Status 504}}<p>The service took too long to respond.</p>
+{{else}}<p>The service is unreachable right now.</p>
+{{end}}<p><small>{{.Status}} {{.StatusText}}</small></p>
+</main>
+</body>
+</html>
+`))
+
+// errorPageData is what error page templates are rendered with
+type errorPageData struct {
+	Service    string
+	Status     int
+	StatusText string
+	RetryAfter int // seconds, 503 only
+}
+
+// errorPages renders the 502, 503 and 504 responses of a service, using its
+// custom templates where it has them
+type errorPages struct {
+	name       string
+	retryAfter int
+	templates  map[int]*template.Template
+	logger     *logger.Logger
+}
+
+// newErrorPages parses the custom error pages of a service. A template that
+// no longer parses falls back to the built-in page.
+func newErrorPages(service *models.ProxyService, log *logger.Logger) *errorPages {
+	e := &errorPages{
+		name:       service.Name,
+		retryAfter: service.MaintenanceRetryAfter,
+		templates:  make(map[int]*template.Template),
+		logger:     log,
+	}
+	if e.retryAfter <= 0 {
+		e.retryAfter = models.DefaultMaintenanceRetryAfter
+	}
+
+	for _, page := range service.ErrorPages {
+		tmpl, err := template.New("error").Parse(page.Template)
+		if err != nil {
+			log.Printf("[Proxy] %s -> invalid %d error page, using the built-in one: %v", service.Name, page.Status, err)
+			continue
+		}
+		e.templates[page.Status] = tmpl
+	}
+	return e
+}
+
+// serve writes the error page for status. Clients that do not accept HTML
+// get the status text only.
+func (e *errorPages) serve(w http.ResponseWriter, r *http.Request, status int) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	if status == http.StatusServiceUnavailable && h.Get("Retry-After") == "" {
+		h.Set("Retry-After", strconv.Itoa(e.retryAfter))
+	}
+
+	if !acceptsHTML(r) {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	data := errorPageData{
+		Service:    e.name,
+		Status:     status,
+		StatusText: http.StatusText(status),
+	}
+	if status == http.StatusServiceUnavailable {
+		data.RetryAfter, _ = strconv.Atoi(h.Get("Retry-After"))
+	}
+
+	// Render to a buffer first so a failing custom template can still fall
+	// back to the built-in page
+	var buf bytes.Buffer
+	if tmpl, ok := e.templates[status]; ok {
+		if err := tmpl.Execute(&buf, data); err != nil {
+			e.logger.Printf("[Proxy] %s -> failed to render %d error page: %v", e.name, status, err)
+			buf.Reset()
+		}
+	}
+	if buf.Len() == 0 {
+		_ = defaultErrorPage.Execute(&buf, data)
+	}
+
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Del("Content-Length")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// maintenance answers every request with the 503 page while the service is
+// in maintenance mode
+func (e *errorPages) maintenance(service *models.ProxyService, next http.Handler) http.Handler {
+	if !service.Maintenance {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.serve(w, r, http.StatusServiceUnavailable)
+	})
+}
+
+// upstreamErrorStatus returns 504 for upstream timeouts and 502 for every
+// other proxy error
+func upstreamErrorStatus(err error) int {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// acceptsHTML reports whether a client takes an HTML response. Clients
+// without an Accept header, like most command line tools, are served HTML.
+func acceptsHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "text/html") || strings.Contains(accept, "*/*")
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// errServiceExpired is returned for new sessions of a service past its
+// lifetime
+var errServiceExpired = errors.New("service expired")
+
+// serviceLifetime enforces the expiry time and use limit of a temporary
+// service. A use is one client connection: an HTTP or TCP connection, or a
+// UDP session.
+type serviceLifetime struct {
+	id      string
+	expire  func(id string) // called once when the lifetime runs out
+	uses    atomic.Int64
+	flushed atomic.Int64 // uses last written to the database
+
+	expiresAt time.Time // zero for no expiry
+	maxUses   int64     // zero for no limit
+	triggered bool
+	mu        sync.Mutex
+}
+
+// configure applies the lifetime settings of a service. Extending an expired
+// lifetime lets it trigger again.
+func (l *serviceLifetime) configure(service *models.ProxyService) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expiresAt = time.Time{}
+	if service.ExpiresAt != nil {
+		l.expiresAt = *service.ExpiresAt
+	}
+	l.maxUses = int64(service.MaxUses)
+	l.triggered = false
+}
+
+// use counts a new request or session, returning false once the service has
+// expired. The first refusal triggers the expiry right away rather than at
+// the next sweep. A nil lifetime allows everything.
+func (l *serviceLifetime) use() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	ok := l.expiresAt.IsZero() || time.Now().Before(l.expiresAt)
+	if ok && l.maxUses > 0 && l.uses.Load() >= l.maxUses {
+		ok = false
+	}
+	if ok {
+		l.uses.Add(1)
+	}
+	trigger := !ok && !l.triggered
+	if trigger {
+		l.triggered = true
+	}
+	l.mu.Unlock()
+
+	if trigger {
+		go l.expire(l.id)
+	}
+	return ok
+}
+
+// current reports whether the expiry time has not passed yet, without
+// counting a use. A nil lifetime never expires.
+func (l *serviceLifetime) current() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt.IsZero() || time.Now().Before(l.expiresAt)
+}
+
+// connUsesKey is the request context key of the services an HTTP connection
+// has been counted against
+type connUsesKey struct{}
+
+// withConnUses is the http.Server ConnContext that lets temporary services
+// count each client connection once rather than every request on it
+func withConnUses(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connUsesKey{}, new(sync.Map))
+}
+
+// lifetimeFor returns the lifetime of a service with its current settings
+// applied, or nil if the service never expires. Uses are carried over from
+// the database the first time.
+func (p *ProxyProvider) lifetimeFor(service *models.ProxyService) *serviceLifetime {
+	p.mu.Lock()
+	if service.ExpiresAt == nil && service.MaxUses == 0 {
+		delete(p.lifetimes, service.ID)
+		p.mu.Unlock()
+		return nil
+	}
+	l, exists := p.lifetimes[service.ID]
+	if !exists {
+		l = &serviceLifetime{id: service.ID, expire: p.expireService}
+		l.uses.Store(int64(service.Uses))
+		l.flushed.Store(int64(service.Uses))
+		p.lifetimes[service.ID] = l
+	}
+	p.mu.Unlock()
+
+	l.configure(service)
+	return l
+}
+
+// enforceLifetime rejects requests to a service past its lifetime with 410.
+// The first request on a client connection counts as a use; later ones on
+// the same connection only need the service to be unexpired. It sits inside
+// the access gate and maintenance page so only admitted requests count.
+func (p *ProxyProvider) enforceLifetime(service *models.ProxyService, next http.Handler) http.Handler {
+	l := p.lifetimeFor(service)
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uses, _ := r.Context().Value(connUsesKey{}).(*sync.Map)
+		counted := false
+		if uses != nil {
+			_, counted = uses.Load(service.ID)
+		}
+
+		ok := l.current()
+		if !counted {
+			ok = l.use()
+		}
+		if !ok {
+			http.Error(w, "Gone", http.StatusGone)
+			return
+		}
+		if uses != nil && !counted {
+			uses.Store(service.ID, true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serviceExpired reports whether a service is past its expiry time or has
+// used up its uses
+func (p *ProxyProvider) serviceExpired(service *models.ProxyService, now time.Time) bool {
+	if service.ExpiresAt != nil && !now.Before(*service.ExpiresAt) {
+		return true
+	}
+	return service.MaxUses > 0 && p.serviceUses(service) >= service.MaxUses
+}
+
+// serviceUses returns the uses counted for a service, including those not yet
+// written to the database
+func (p *ProxyProvider) serviceUses(service *models.ProxyService) int {
+	p.mu.RLock()
+	l, exists := p.lifetimes[service.ID]
+	p.mu.RUnlock()
+
+	if !exists {
+		return service.Uses
+	}
+	return max(int(l.uses.Load()), service.Uses)
+}
+
+// expireServices disables or deletes every expired service and saves the
+// use counts of the others
+func (p *ProxyProvider) expireServices(now time.Time) {
+	services, err := p.repo.GetServices()
+	if err != nil {
+		p.logger.Printf("[Proxy] Failed to load services for expiry: %v", err)
+		return
+	}
+
+	for _, service := range services {
+		if p.serviceExpired(service, now) {
+			if service.Enabled || service.ExpireAction == models.ExpireDelete {
+				p.expireService(service.ID)
+			}
+			continue
+		}
+		p.flushUses(service.ID)
+	}
+}
+
+// expireService disables or deletes a service at the end of its lifetime,
+// syncing the change to the cloud
+func (p *ProxyProvider) expireService(id string) {
+	p.expireMu.Lock()
+	defer p.expireMu.Unlock()
+
+	p.flushUses(id)
+
+	service, err := p.repo.GetService(id)
+	if err != nil {
+		// Already deleted
+		return
+	}
+	if !p.serviceExpired(service, time.Now()) {
+		return
+	}
+
+	if service.ExpireAction == models.ExpireDelete {
+		if err := p.DeleteService(id); err != nil {
+			p.logger.Printf("[Proxy] %s -> failed to delete expired service: %v", service.Name, err)
+			return
+		}
+		p.logger.Printf("[Proxy] %s -> expired, deleted", service.Name)
+		return
+	}
+
+	if !service.Enabled {
+		return
+	}
+	if err := p.DisableService(id); err != nil {
+		p.logger.Printf("[Proxy] %s -> failed to disable expired service: %v", service.Name, err)
+		return
+	}
+	p.logger.Printf("[Proxy] %s -> expired, disabled", service.Name)
+}
+
+// flushUses writes the uses of a service to the database if they changed
+func (p *ProxyProvider) flushUses(id string) {
+	p.mu.RLock()
+	l, exists := p.lifetimes[id]
+	p.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+	uses := l.uses.Load()
+	if l.flushed.Load() == uses {
+		return
+	}
+	if err := p.repo.SetServiceUses(id, int(uses)); err != nil {
+		p.logger.Printf("[Proxy] Failed to save uses of service %s: %v", id, err)
+		return
+	}
+	l.flushed.Store(uses)
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestLifetime returns a lifetime configured for service that ignores
+// its expiry
+func newTestLifetime(service *models.ProxyService) *serviceLifetime {
+	l := &serviceLifetime{id: service.ID, expire: func(string) {}}
+	l.configure(service)
+	return l
+}
+
+func TestServiceLifetimeUse(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		service *models.ProxyService
+		uses    int64 // counted before
+		want    []bool
+	}{
+		{name: "unlimited", service: &models.ProxyService{}, want: []bool{true, true, true}},
+		{name: "use limit", service: &models.ProxyService{MaxUses: 2}, want: []bool{true, true, false, false}},
+		{name: "uses carried over", service: &models.ProxyService{MaxUses: 2}, uses: 2, want: []bool{false}},
+		{name: "not yet expired", service: &models.ProxyService{ExpiresAt: &future}, want: []bool{true, true}},
+		{name: "expired", service: &models.ProxyService{ExpiresAt: &past}, want: []bool{false, false}},
+		{name: "both", service: &models.ProxyService{ExpiresAt: &future, MaxUses: 1}, want: []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLifetime(tt.service)
+			l.uses.Store(tt.uses)
+
+			refused := 0
+			for i, want := range tt.want {
+				if got := l.use(); got != want {
+					t.Fatalf("use #%d = %v, want %v", i+1, got, want)
+				}
+				if !want {
+					refused++
+				}
+			}
+
+			allowed := int64(len(tt.want) - refused)
+			if got := l.uses.Load(); got != tt.uses+allowed {
+				t.Fatalf("uses = %d, want %d", got, tt.uses+allowed)
+			}
+		})
+	}
+}
+
+func TestServiceLifetimeTriggersOnce(t *testing.T) {
+	triggered := make(chan string, 4)
+	l := &serviceLifetime{id: "svc", expire: func(id string) { triggered <- id }}
+	l.configure(&models.ProxyService{MaxUses: 1})
+
+	l.use()
+	l.use()
+	l.use()
+	if id := <-triggered; id != "svc" {
+		t.Fatalf("expired %q, want svc", id)
+	}
+	select {
+	case <-triggered:
+		t.Fatal("expiry triggered twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Extending the lifetime lets it trigger again
+	l.configure(&models.ProxyService{MaxUses: 3})
+	if !l.use() || !l.use() {
+		t.Fatal("use refused after raising the limit")
+	}
+	if l.use() {
+		t.Fatal("use allowed past the raised limit")
+	}
+	if id := <-triggered; id != "svc" {
+		t.Fatalf("expired %q, want svc", id)
+	}
+}
+
+func TestServiceLifetimeCurrent(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		service *models.ProxyService
+		want    bool
+	}{
+		{name: "nil", want: true},
+		{name: "future", service: &models.ProxyService{ExpiresAt: &future}, want: true},
+		{name: "past", service: &models.ProxyService{ExpiresAt: &past}, want: false},
+		{name: "uses do not matter", service: &models.ProxyService{MaxUses: 1}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l *serviceLifetime
+			if tt.service != nil {
+				l = newTestLifetime(tt.service)
+				l.uses.Store(5)
+			}
+			if got := l.current(); got != tt.want {
+				t.Fatalf("current = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceLifetimeCountsConnections(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{ID: "svc", Name: "test", MaxUses: 1}
+	p.lifetimes[service.ID] = &serviceLifetime{id: service.ID, expire: func(string) {}}
+
+	handler := p.enforceLifetime(service, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnContext = withConnUses
+	server.Start()
+	defer server.Close()
+
+	get := func(client *http.Client) int {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	first := &http.Client{Transport: &http.Transport{}}
+	for i := range 3 {
+		if status := get(first); status != http.StatusNoContent {
+			t.Fatalf("request %d on the first connection: status %d, want 204", i+1, status)
+		}
+	}
+
+	second := &http.Client{Transport: &http.Transport{}}
+	if status := get(second); status != http.StatusGone {
+		t.Fatalf("second connection: status %d, want 410", status)
+	}
+	if uses := p.lifetimes[service.ID].uses.Load(); uses != 1 {
+		t.Fatalf("uses = %d, want 1", uses)
+	}
+}
+
+func TestLifetimeExpiry(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		ttl       int
+		expiresAt *time.Time
+		want      time.Duration // from now, 0 for no expiry
+		wantErr   string
+	}{
+		{name: "none"},
+		{name: "ttl", ttl: 90, want: 90 * time.Second},
+		{name: "expires at", expiresAt: &future, want: time.Hour},
+		{name: "both", ttl: 90, expiresAt: &future, wantErr: "not both"},
+		{name: "negative ttl", ttl: -1, wantErr: "cannot be negative"},
+		{name: "past", expiresAt: &past, wantErr: "in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lifetimeExpiry(&tt.ttl, tt.expiresAt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.want == 0 && got != nil:
+				t.Fatalf("expiry = %s, want none", got)
+			case tt.want != 0 && (got == nil || time.Until(*got) > tt.want || time.Until(*got) < tt.want-time.Minute):
+				t.Fatalf("expiry = %v, want in %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// serviceHandler returns the HTTP handler for a service, building it on first
+// use. The handler is shared by every listener serving the service.
+func (p *ProxyProvider) serviceHandler(service *models.ProxyService) (*swappableHandler, error) {
+	p.mu.RLock()
+	handler, exists := p.handlers[service.ID]
+	p.mu.RUnlock()
+
+	if exists {
+		return handler, nil
+	}
+
+	built, err := p.newServiceHandler(service)
+	if err != nil {
+		return nil, err
+	}
+	handler = newSwappableHandler(built)
+
+	p.mu.Lock()
+	if existing, exists := p.handlers[service.ID]; exists {
+		handler = existing
+	} else {
+		p.handlers[service.ID] = handler
+	}
+	p.mu.Unlock()
+
+	return handler, nil
+}
+
+// proxyRequestKey is the request context key of a proxied request's state
+type proxyRequestKey struct{}
+
+// proxyRequest is the state of one request passing through a balancedProxy
+type proxyRequest struct {
+	target *upstreamTarget
+	vars   *strings.Replacer // header rule placeholders, nil without rules
+}
+
+// proxyRequestFrom returns the proxied request state stored in ctx
+func proxyRequestFrom(ctx context.Context) *proxyRequest {
+	return ctx.Value(proxyRequestKey{}).(*proxyRequest)
+}
+
+// balancedProxy picks an upstream target for each request before handing it
+// to the reverse proxy
+type balancedProxy struct {
+	lb    *balancer
+	rules *headerRules
+	proxy *httputil.ReverseProxy
+}
+
+// ServeHTTP implements http.Handler
+func (bp *balancedProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pr := &proxyRequest{target: bp.lb.acquire()}
+	// Retries may move the request to another target
+	defer func() { bp.lb.release(pr.target) }()
+
+	if len(bp.rules.request) > 0 || len(bp.rules.response) > 0 {
+		pr.vars = headerVars(r)
+	}
+
+	ctx := context.WithValue(r.Context(), proxyRequestKey{}, pr)
+	bp.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// newServiceHandler builds the reverse proxy handler for an HTTP or websocket
+// service, dispatching to its path routes when it has any, behind the
+// service's access rules, client limits, maintenance page, gate and lifetime,
+// with every request counted and written to the access log
+func (p *ProxyProvider) newServiceHandler(service *models.ProxyService) (http.Handler, error) {
+	pages := newErrorPages(service, p.logger)
+
+	handler, err := p.newRoutingHandler(service, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	handler = p.capture(service, handler)
+	handler = p.cacheResponses(service, handler)
+	handler = p.enforceLifetime(service, handler)
+	handler = newAuthGate(service, handler, p.logger)
+	handler = pages.maintenance(service, handler)
+	handler = p.rateLimit(service, handler)
+	handler = p.newAccessFilter(service).wrap(handler)
+	handler = p.instrument(service, handler)
+	handler = resolveTimeouts(service).withDeadlines(handler)
+
+	return handler, nil
+}
+
+// newRoutingHandler builds the proxy to a service's upstreams, dispatching to
+// its path routes when it has any. Only traffic to the main upstream is
+// mirrored to the shadow upstream.
+func (p *ProxyProvider) newRoutingHandler(service *models.ProxyService, pages *errorPages) (http.Handler, error) {
+	proxy, err := p.newUpstreamProxy(service, p.balancerFor(service), "", pages)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror, err := newShadowMirror(service, p.statsFor(service.ID), p.logger)
+	if err != nil {
+		return nil, err
+	}
+	fallback := mirror.wrap(proxy)
+
+	p.pruneRouteBreakers(service)
+	if len(service.Routes) == 0 {
+		return fallback, nil
+	}
+
+	router := &pathRouter{fallback: fallback}
+	for _, route := range service.Routes {
+		proxy, err := p.newUpstreamProxy(service, p.routeBalancerFor(service, route), route.PathPrefix, pages)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.PathPrefix, err)
+		}
+		router.routes = append(router.routes, pathRoute{
+			prefix:      route.PathPrefix,
+			stripPrefix: route.StripPrefix,
+			handler:     proxy,
+		})
+	}
+
+	// Longest prefix wins
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+	})
+
+	return router, nil
+}
+
+// newUpstreamProxy builds a reverse proxy from a service to the upstream
+// targets of a balancer, answering upstream failures with the service's error
+// pages. route is the path prefix of the route the balancer serves, or empty
+// for the main upstreams.
+func (p *ProxyProvider) newUpstreamProxy(service *models.ProxyService, lb *balancer, route string, pages *errorPages) (*balancedProxy, error) {
+	scheme := upstreamScheme(service)
+	rules := newHeaderRules(service)
+	compression := newResponseCompression(service)
+
+	transport, err := upstreamTransport(service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure upstream transport: %w", err)
+	}
+
+	stats := p.statsFor(service.ID)
+	breaker := p.breakerFor(service, route)
+
+	proxy := &httputil.ReverseProxy{
+		Transport:     transport,
+		FlushInterval: resolveTimeouts(service).flushInterval,
+	}
+	if breaker != nil || service.RetryAttempts > 0 {
+		proxy.Transport = &resilientTransport{
+			next:    transport,
+			lb:      lb,
+			breaker: breaker,
+			retries: service.RetryAttempts,
+			stats:   stats,
+			logger:  p.logger,
+		}
+	}
+
+	proxy.Director = func(req *http.Request) {
+		pr := proxyRequestFrom(req.Context())
+		req.URL.Scheme = scheme
+		req.URL.Host = pr.target.addr
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// Keep the transport from adding its own default
+			req.Header.Set("User-Agent", "")
+		}
+
+		// Set the Host header to the target host (required for HA and other apps that check Host)
+		req.Host = pr.target.addr
+
+		// Add forwarded headers
+		if req.Header.Get("X-Forwarded-Proto") == "" {
+			if req.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+		}
+		if req.Header.Get("X-Forwarded-For") == "" {
+			if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				req.Header.Set("X-Forwarded-For", clientIP)
+			}
+		}
+
+		// Service rules run last so they can override the defaults above
+		applyHeaderRules(req.Header, rules.request, pr.vars)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		pr := proxyRequestFrom(resp.Request.Context())
+		lb.markSuccess(pr.target)
+		compression.apply(resp)
+		applyHeaderRules(resp.Header, rules.response, pr.vars)
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, errBreakerOpen) {
+			p.logger.Debug("[Proxy] %s -> circuit breaker open, rejected %s", service.Name, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(breaker.retryAfter().Seconds()))))
+			pages.serve(w, r, http.StatusServiceUnavailable)
+			return
+		}
+
+		p.logger.Printf("Proxy error for service %s: %v", service.Name, err)
+		if !errors.Is(err, context.Canceled) {
+			stats.upstreamErrors.Add(1)
+			lb.markFailure(proxyRequestFrom(r.Context()).target, err)
+		}
+		pages.serve(w, r, upstreamErrorStatus(err))
+	}
+
+	return &balancedProxy{lb: lb, rules: rules, proxy: proxy}, nil
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// headerRules are a service's header rewrites split by direction
+type headerRules struct {
+	request  []models.ProxyServiceHeaderRule
+	response []models.ProxyServiceHeaderRule
+}
+
+// newHeaderRules groups a service's header rules by direction, keeping order
+func newHeaderRules(service *models.ProxyService) *headerRules {
+	rules := &headerRules{}
+	for _, rule := range service.HeaderRules {
+		if rule.Direction == models.HeaderResponse {
+			rules.response = append(rules.response, rule)
+		} else {
+			rules.request = append(rules.request, rule)
+		}
+	}
+	return rules
+}
+
+// headerVars returns the replacer for the placeholders allowed in header rule
+// values, taken from the request as the client sent it
+func headerVars(r *http.Request) *strings.Replacer {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.NewReplacer(
+		"{host}", r.Host,
+		"{client_ip}", clientIP(r.RemoteAddr),
+		"{scheme}", scheme,
+	)
+}
+
+// applyHeaderRules applies rules in order to h
+func applyHeaderRules(h http.Header, rules []models.ProxyServiceHeaderRule, vars *strings.Replacer) {
+	for _, rule := range rules {
+		switch rule.Action {
+		case models.HeaderAdd:
+			h.Add(rule.Name, vars.Replace(rule.Value))
+		case models.HeaderSet:
+			h.Set(rule.Name, vars.Replace(rule.Value))
+		case models.HeaderRemove:
+			h.Del(rule.Name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+func TestApplyHeaderRules(t *testing.T) {
+	rule := func(action, name, value string) models.ProxyServiceHeaderRule {
+		return models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: action, Name: name, Value: value}
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		rules  []models.ProxyServiceHeaderRule
+		tls    bool
+		want   http.Header
+	}{
+		{
+			name:   "add keeps existing values",
+			header: http.Header{"X-Tag": {"a"}},
+			rules:  []models.ProxyServiceHeaderRule{rule(models.HeaderAdd, "X-Tag", "b")},
+			want:   http.Header{"X-Tag": {"a", "b"}},
+		},
+		{
+			name:   "set replaces all values",
+			header: http.Header{"X-Tag": {"a", "b"}},
+			rules:  []models.ProxyServiceHeaderRule{rule(models.HeaderSet, "x-tag", "c")},
+			want:   http.Header{"X-Tag": {"c"}},
+		},
+		{
+			name:   "remove",
+			header: http.Header{"Server": {"nginx"}, "X-Tag": {"a"}},
+			rules:  []models.ProxyServiceHeaderRule{rule(models.HeaderRemove, "server", "")},
+			want:   http.Header{"X-Tag": {"a"}},
+		},
+		{
+			name:   "rules apply in order",
+			header: http.Header{},
+			rules: []models.ProxyServiceHeaderRule{
+				rule(models.HeaderSet, "X-Tag", "a"),
+				rule(models.HeaderRemove, "X-Tag", ""),
+				rule(models.HeaderAdd, "X-Tag", "b"),
+			},
+			want: http.Header{"X-Tag": {"b"}},
+		},
+		{
+			name:   "placeholders",
+			header: http.Header{},
+			rules:  []models.ProxyServiceHeaderRule{rule(models.HeaderSet, "X-Origin", "{scheme}://{host} from {client_ip}")},
+			want:   http.Header{"X-Origin": {"http://app.example.com from 203.0.113.7"}},
+		},
+		{
+			name:   "https scheme",
+			header: http.Header{},
+			rules:  []models.ProxyServiceHeaderRule{rule(models.HeaderSet, "X-Scheme", "{scheme}")},
+			tls:    true,
+			want:   http.Header{"X-Scheme": {"https"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+			req.RemoteAddr = "203.0.113.7:51000"
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			applyHeaderRules(tt.header, tt.rules, headerVars(req))
+			if !reflect.DeepEqual(tt.header, tt.want) {
+				t.Fatalf("headers %v, want %v", tt.header, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHeaderRules(t *testing.T) {
+	service := &models.ProxyService{HeaderRules: []models.ProxyServiceHeaderRule{
+		{Direction: models.HeaderRequest, Name: "A"},
+		{Direction: models.HeaderResponse, Name: "B"},
+		{Direction: models.HeaderRequest, Name: "C"},
+		{Direction: models.HeaderResponse, Name: "D"},
+	}}
+
+	rules := newHeaderRules(service)
+	names := func(rules []models.ProxyServiceHeaderRule) (names []string) {
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+		return names
+	}
+	if got := names(rules.request); !reflect.DeepEqual(got, []string{"A", "C"}) {
+		t.Fatalf("request rules %v, want [A C]", got)
+	}
+	if got := names(rules.response); !reflect.DeepEqual(got, []string{"B", "D"}) {
+		t.Fatalf("response rules %v, want [B D]", got)
+	}
+}
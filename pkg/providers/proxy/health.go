@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// Health states reported for services and their upstream targets
+const (
+	HealthUnknown   = "unknown"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// ProxyServiceHealth is the result of a service's active health checks
+type ProxyServiceHealth struct {
+	Status    string     `json:"status"`
+	LastError string     `json:"last_error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// targetProbeState tracks consecutive probe results of one upstream target
+type targetProbeState struct {
+	lb        *balancer // the balancer the target belongs to
+	status    string
+	successes int
+	failures  int
+}
+
+// healthChecker periodically probes the upstream targets of a service, both
+// its main upstreams and those of its path routes, and takes unhealthy ones
+// out of their balancers
+type healthChecker struct {
+	service  *models.ProxyService
+	lbs      []*balancer
+	client   *http.Client
+	interval time.Duration
+	timeout  time.Duration
+	onChange func(health *ProxyServiceHealth)
+
+	targets   map[*upstreamTarget]*targetProbeState // owned by the run loop
+	status    string
+	lastError string
+	checkedAt time.Time
+	mu        sync.RWMutex
+	cancel    context.CancelFunc
+}
+
+// newHealthChecker builds the checker for a service with health checks
+// enabled, probing the targets of each of its balancers
+func newHealthChecker(service *models.ProxyService, lbs []*balancer, onChange func(*ProxyServiceHealth)) (*healthChecker, error) {
+	hc := &healthChecker{
+		service:  service,
+		lbs:      lbs,
+		interval: secondsOr(service.HealthCheckInterval, defaultHealthCheckInterval),
+		timeout:  secondsOr(service.HealthCheckTimeout, defaultHealthCheckTimeout),
+		onChange: onChange,
+		targets:  make(map[*upstreamTarget]*targetProbeState),
+		status:   HealthUnknown,
+	}
+
+	if service.HealthCheck == models.HealthCheckHTTP {
+		transport, err := upstreamTransport(service)
+		if err != nil {
+			return nil, err
+		}
+		transport.DisableKeepAlives = true
+		hc.client = &http.Client{
+			Transport: transport,
+			Timeout:   hc.timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	for _, lb := range lbs {
+		for _, target := range lb.targets {
+			hc.targets[target] = &targetProbeState{lb: lb, status: HealthUnknown}
+		}
+	}
+
+	return hc, nil
+}
+
+// secondsOr converts a number of seconds to a duration, using def when unset
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// run probes the targets every interval until ctx is cancelled
+func (hc *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll probes every target once and updates the service health
+func (hc *healthChecker) checkAll(ctx context.Context) {
+	type result struct {
+		target *upstreamTarget
+		err    error
+	}
+
+	results := make(chan result, len(hc.targets))
+	for target := range hc.targets {
+		go func(target *upstreamTarget) {
+			results <- result{target: target, err: hc.probe(ctx, target)}
+		}(target)
+	}
+
+	var lastErr error
+	for range hc.targets {
+		r := <-results
+		if r.err != nil {
+			lastErr = fmt.Errorf("%s: %w", r.target.addr, r.err)
+		}
+		hc.record(r.target, r.err)
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+	hc.update(lastErr)
+}
+
+// probe runs one health check against a target
+func (hc *healthChecker) probe(ctx context.Context, target *upstreamTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	if hc.service.HealthCheck == models.HealthCheckTCP {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", target.addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	path := hc.service.HealthCheckPath
+	if path == "" {
+		path = "/"
+	}
+
+	url := fmt.Sprintf("%s://%s%s", upstreamScheme(hc.service), target.addr, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "arqut-edge-health-check")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if expected := hc.service.HealthCheckStatus; expected != 0 {
+		if resp.StatusCode != expected {
+			return fmt.Errorf("unexpected status %d (expected %d)", resp.StatusCode, expected)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// record applies a probe result to a target, flipping its state once the
+// configured threshold of consecutive results is reached
+func (hc *healthChecker) record(target *upstreamTarget, err error) {
+	state := hc.targets[target]
+
+	if err == nil {
+		state.failures = 0
+		state.successes++
+		if state.status != HealthHealthy && state.successes >= thresholdOr(hc.service.HealthyThreshold, defaultHealthyThreshold) {
+			state.status = HealthHealthy
+			target.unhealthy.Store(false)
+			state.lb.logger.Printf("[Proxy] %s -> upstream %s is healthy", hc.service.Name, target.addr)
+		}
+		return
+	}
+
+	state.successes = 0
+	state.failures++
+	if state.status != HealthUnhealthy && state.failures >= thresholdOr(hc.service.UnhealthyThreshold, defaultUnhealthyThreshold) {
+		state.status = HealthUnhealthy
+		target.unhealthy.Store(true)
+		state.lb.logger.Printf("[Proxy] %s -> upstream %s is unhealthy: %v", hc.service.Name, target.addr, err)
+	}
+}
+
+// thresholdOr returns threshold, or def when it is unset
+func thresholdOr(threshold, def int) int {
+	if threshold <= 0 {
+		return def
+	}
+	return threshold
+}
+
+// update derives the service health from its targets: healthy while each
+// balancer has a healthy target, unhealthy once any of them has none left.
+// The last error is kept until the service is healthy again.
+func (hc *healthChecker) update(lastErr error) {
+	status := HealthHealthy
+	for _, lb := range hc.lbs {
+		lbStatus := HealthUnhealthy
+		for _, target := range lb.targets {
+			if state := hc.targets[target]; state.status == HealthHealthy {
+				lbStatus = HealthHealthy
+				break
+			} else if state.status == HealthUnknown {
+				lbStatus = HealthUnknown
+			}
+		}
+		if lbStatus == HealthUnhealthy {
+			status = HealthUnhealthy
+			break
+		}
+		if lbStatus == HealthUnknown {
+			status = HealthUnknown
+		}
+	}
+
+	hc.mu.Lock()
+	changed := status != hc.status
+	hc.status = status
+	switch {
+	case lastErr != nil:
+		hc.lastError = lastErr.Error()
+	case status == HealthHealthy:
+		hc.lastError = ""
+	}
+	hc.checkedAt = time.Now()
+	hc.mu.Unlock()
+
+	if changed && hc.onChange != nil {
+		hc.onChange(hc.snapshot())
+	}
+}
+
+// snapshot returns the current service health
+func (hc *healthChecker) snapshot() *ProxyServiceHealth {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	health := &ProxyServiceHealth{
+		Status:    hc.status,
+		LastError: hc.lastError,
+	}
+	if !hc.checkedAt.IsZero() {
+		checkedAt := hc.checkedAt
+		health.CheckedAt = &checkedAt
+	}
+	return health
+}
+
+// startHealthCheck starts active health checks for a service if it has them
+// enabled
+func (p *ProxyProvider) startHealthCheck(ctx context.Context, service *models.ProxyService) {
+	if service.HealthCheck == models.HealthCheckNone {
+		return
+	}
+
+	hc, err := newHealthChecker(service, p.serviceBalancers(service), func(health *ProxyServiceHealth) {
+		p.logger.Printf("[Proxy] Service %s is %s", service.Name, health.Status)
+		p.queueServiceSync("health", service, map[string]any{"health": health})
+	})
+	if err != nil {
+		p.logger.Printf("Failed to start health checks for %s: %v", service.Name, err)
+		return
+	}
+
+	checkCtx, cancel := context.WithCancel(ctx)
+	hc.cancel = cancel
+
+	p.mu.Lock()
+	if existing, exists := p.healthCheckers[service.ID]; exists {
+		existing.cancel()
+	}
+	p.healthCheckers[service.ID] = hc
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		hc.run(checkCtx)
+	}()
+}
+
+// stopHealthCheck stops the health checks of a service
+func (p *ProxyProvider) stopHealthCheck(id string) {
+	p.mu.Lock()
+	hc, exists := p.healthCheckers[id]
+	delete(p.healthCheckers, id)
+	p.mu.Unlock()
+
+	if exists {
+		hc.cancel()
+	}
+}
+
+// GetServiceHealth returns the health of a service, or nil if it has no
+// active health checks running
+func (p *ProxyProvider) GetServiceHealth(id string) *ProxyServiceHealth {
+	p.mu.RLock()
+	hc, exists := p.healthCheckers[id]
+	p.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return hc.snapshot()
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// testUpstream is a TCP listener that can be stopped and restarted on the
+// same address
+type testUpstream struct {
+	t        *testing.T
+	addr     string
+	listener net.Listener
+}
+
+// newTestUpstream listens on a free local port, accepting and closing
+// connections
+func newTestUpstream(t *testing.T) *testUpstream {
+	u := &testUpstream{t: t, addr: "127.0.0.1:0"}
+	u.start()
+	u.addr = u.listener.Addr().String()
+	t.Cleanup(u.stop)
+	return u
+}
+
+func (u *testUpstream) start() {
+	listener, err := net.Listen("tcp", u.addr)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	u.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+}
+
+func (u *testUpstream) stop() {
+	u.listener.Close()
+}
+
+func (u *testUpstream) port() int {
+	_, port, _ := net.SplitHostPort(u.addr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestHealthCheckerTransitions(t *testing.T) {
+	upstream := newTestUpstream(t)
+	service := &models.ProxyService{
+		Name:               "test",
+		LocalHost:          "127.0.0.1",
+		LocalPort:          upstream.port(),
+		HealthCheck:        models.HealthCheckTCP,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}
+	lb := newBalancer(service, newTestLogger())
+
+	var changes []string
+	hc, err := newHealthChecker(service, []*balancer{lb}, func(health *ProxyServiceHealth) {
+		changes = append(changes, health.Status)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name          string
+		up            bool
+		wantStatus    string
+		wantLastError bool
+	}{
+		{name: "first success", up: true, wantStatus: HealthUnknown},
+		{name: "healthy threshold", up: true, wantStatus: HealthHealthy},
+		{name: "first failure", up: false, wantStatus: HealthHealthy, wantLastError: true},
+		{name: "unhealthy threshold", up: false, wantStatus: HealthUnhealthy, wantLastError: true},
+		{name: "first recovery", up: true, wantStatus: HealthUnhealthy, wantLastError: true},
+		{name: "recovered", up: true, wantStatus: HealthHealthy},
+	}
+
+	running := true
+	for _, step := range steps {
+		if step.up != running {
+			if step.up {
+				upstream.start()
+			} else {
+				upstream.stop()
+			}
+			running = step.up
+		}
+
+		hc.checkAll(context.Background())
+		health := hc.snapshot()
+		if health.Status != step.wantStatus {
+			t.Fatalf("%s: status = %s, want %s", step.name, health.Status, step.wantStatus)
+		}
+		if (health.LastError != "") != step.wantLastError {
+			t.Fatalf("%s: last error = %q, want set %v", step.name, health.LastError, step.wantLastError)
+		}
+		if available := lb.targets[0].available(time.Now()); available != (step.wantStatus != HealthUnhealthy) {
+			t.Fatalf("%s: target available = %v", step.name, available)
+		}
+	}
+
+	want := []string{HealthHealthy, HealthUnhealthy, HealthHealthy}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestHealthCheckerProbesRoutes(t *testing.T) {
+	main := newTestUpstream(t)
+	route := newTestUpstream(t)
+	service := &models.ProxyService{
+		Name:               "test",
+		LocalHost:          "127.0.0.1",
+		LocalPort:          main.port(),
+		HealthCheck:        models.HealthCheckTCP,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}
+	lbs := []*balancer{
+		newBalancer(service, newTestLogger()),
+		newSingleTargetBalancer("test", "127.0.0.1", route.port(), newTestLogger()),
+	}
+
+	hc, err := newHealthChecker(service, lbs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc.checkAll(context.Background())
+	if status := hc.snapshot().Status; status != HealthHealthy {
+		t.Fatalf("status = %s with every upstream up, want %s", status, HealthHealthy)
+	}
+
+	route.stop()
+	hc.checkAll(context.Background())
+	if status := hc.snapshot().Status; status != HealthUnhealthy {
+		t.Fatalf("status = %s with the route upstream down, want %s", status, HealthUnhealthy)
+	}
+	if !lbs[1].targets[0].unhealthy.Load() {
+		t.Fatal("route target not marked unhealthy")
+	}
+	if lbs[0].targets[0].unhealthy.Load() {
+		t.Fatal("main target marked unhealthy")
+	}
+}
+
+func TestHealthCheckHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected int
+		wantErr  bool
+	}{
+		{name: "2xx", status: http.StatusNoContent},
+		{name: "3xx", status: http.StatusFound},
+		{name: "5xx", status: http.StatusServiceUnavailable, wantErr: true},
+		{name: "expected status", status: http.StatusTeapot, expected: http.StatusTeapot},
+		{name: "other than expected", status: http.StatusOK, expected: http.StatusTeapot, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				if tt.status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+			localPort, _ := strconv.Atoi(port)
+			service := &models.ProxyService{
+				Name:              "test",
+				LocalHost:         host,
+				LocalPort:         localPort,
+				HealthCheck:       models.HealthCheckHTTP,
+				HealthCheckPath:   "/healthz",
+				HealthCheckStatus: tt.expected,
+			}
+			lb := newBalancer(service, newTestLogger())
+			hc, err := newHealthChecker(service, []*balancer{lb}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = hc.probe(context.Background(), lb.targets[0])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("probe error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != "/healthz" {
+				t.Fatalf("probed path = %q, want /healthz", path)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"strconv"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
+)
+
+// CollectMetrics implements metrics.Collector with the traffic counters of
+// every service
+func (p *ProxyProvider) CollectMetrics(w *metrics.Writer) {
+	if p.repo == nil {
+		return
+	}
+	services, err := p.repo.GetServices()
+	if err != nil {
+		p.logger.Printf("Failed to load services for metrics: %v", err)
+		return
+	}
+
+	type serviceMetrics struct {
+		labels  []metrics.Label
+		stats   *serviceStats
+		breaker *circuitBreaker
+	}
+
+	p.mu.RLock()
+	collected := make([]serviceMetrics, 0, len(services))
+	for _, service := range services {
+		stats, exists := p.stats[service.ID]
+		if !exists {
+			continue
+		}
+		collected = append(collected, serviceMetrics{
+			labels: []metrics.Label{
+				metrics.L("service_id", service.ID),
+				metrics.L("service", service.Name),
+				metrics.L("protocol", service.Protocol),
+			},
+			stats:   stats,
+			breaker: p.breakers[service.ID],
+		})
+	}
+	p.mu.RUnlock()
+
+	w.Family("arqut_proxy_requests_total", "HTTP requests handled, by status class.", metrics.TypeCounter)
+	for _, m := range collected {
+		for class := 1; class < len(m.stats.requests); class++ {
+			if n := m.stats.requests[class].Load(); n > 0 {
+				w.Sample("arqut_proxy_requests_total", float64(n),
+					append(m.labels, metrics.L("code", strconv.Itoa(class)+"xx"))...)
+			}
+		}
+	}
+
+	w.Family("arqut_proxy_request_duration_seconds", "HTTP request latency.", metrics.TypeHistogram)
+	for _, m := range collected {
+		w.Histogram("arqut_proxy_request_duration_seconds", m.stats.latency, m.labels...)
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(*serviceStats) int64
+	}{
+		{"arqut_proxy_received_bytes_total", "Bytes received from clients.", func(s *serviceStats) int64 { return s.bytesIn.Load() }},
+		{"arqut_proxy_sent_bytes_total", "Bytes sent to clients.", func(s *serviceStats) int64 { return s.bytesOut.Load() }},
+		{"arqut_proxy_upstream_errors_total", "Failed upstream connections and requests.", func(s *serviceStats) int64 { return s.upstreamErrors.Load() }},
+		{"arqut_proxy_sessions_total", "TCP and UDP sessions opened.", func(s *serviceStats) int64 { return s.totalSessions.Load() }},
+		{"arqut_proxy_denied_total", "Clients rejected by access rules.", func(s *serviceStats) int64 { return s.denied.Load() }},
+		{"arqut_proxy_limited_total", "Clients rejected by rate or connection limits.", func(s *serviceStats) int64 { return s.limited.Load() }},
+		{"arqut_proxy_retries_total", "Upstream requests retried after a failure.", func(s *serviceStats) int64 { return s.retries.Load() }},
+		{"arqut_proxy_breaker_trips_total", "Times the circuit breaker opened.", func(s *serviceStats) int64 { return s.breakerTrips.Load() }},
+		{"arqut_proxy_breaker_rejected_total", "Requests rejected by an open circuit breaker.", func(s *serviceStats) int64 { return s.breakerRejected.Load() }},
+		{"arqut_proxy_mirrored_total", "Requests copied to the shadow upstream.", func(s *serviceStats) int64 { return s.mirrored.Load() }},
+		{"arqut_proxy_mirror_errors_total", "Copied requests the shadow upstream failed to answer.", func(s *serviceStats) int64 { return s.mirrorErrors.Load() }},
+		{"arqut_proxy_mirror_dropped_total", "Sampled requests not copied to the shadow upstream.", func(s *serviceStats) int64 { return s.mirrorDropped.Load() }},
+	}
+	for _, c := range counters {
+		w.Family(c.name, c.help, metrics.TypeCounter)
+		for _, m := range collected {
+			w.Sample(c.name, float64(c.value(m.stats)), m.labels...)
+		}
+	}
+
+	w.Family("arqut_proxy_active_sessions", "Open TCP and UDP sessions.", metrics.TypeGauge)
+	for _, m := range collected {
+		w.Sample("arqut_proxy_active_sessions", float64(m.stats.activeSessions.Load()), m.labels...)
+	}
+
+	w.Family("arqut_proxy_breaker_state", "Circuit breaker state: 0 closed, 1 half-open, 2 open.", metrics.TypeGauge)
+	for _, m := range collected {
+		if m.breaker == nil {
+			continue
+		}
+		state := 0.0
+		switch m.breaker.snapshot().State {
+		case BreakerHalfOpen:
+			state = 1
+		case BreakerOpen:
+			state = 2
+		}
+		w.Sample("arqut_proxy_breaker_state", state, m.labels...)
+	}
+}
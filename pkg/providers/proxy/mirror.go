@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// mirrorBodyLimit is the largest request body copied to the shadow
+	// upstream. Requests with larger bodies are not mirrored.
+	mirrorBodyLimit = 1 << 20
+	// mirrorMaxInFlight bounds the mirrored requests of a service waiting on
+	// the shadow upstream. Requests over the bound are dropped.
+	mirrorMaxInFlight = 16
+	// mirrorTimeout bounds one mirrored request
+	mirrorTimeout = 30 * time.Second
+)
+
+// shadowMirror sends an asynchronous copy of a share of a service's requests
+// to its shadow upstream and discards the responses. Nothing it does is
+// visible to the client of the original request.
+type shadowMirror struct {
+	name    string
+	scheme  string
+	addr    string // host:port
+	percent int
+	rules   *headerRules
+	client  *http.Client
+	stats   *serviceStats
+	logger  *logger.Logger
+
+	inFlight chan struct{}
+}
+
+// newShadowMirror builds the mirror of a service, or returns nil if it has no
+// shadow upstream
+func newShadowMirror(service *models.ProxyService, stats *serviceStats, log *logger.Logger) (*shadowMirror, error) {
+	if service.ShadowHost == "" || service.ShadowPort == 0 || service.ShadowPercent <= 0 {
+		return nil, nil
+	}
+
+	transport, err := upstreamTransport(service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure shadow transport: %w", err)
+	}
+
+	return &shadowMirror{
+		name:    service.Name,
+		scheme:  upstreamScheme(service),
+		addr:    net.JoinHostPort(service.ShadowHost, strconv.Itoa(service.ShadowPort)),
+		percent: service.ShadowPercent,
+		rules:   newHeaderRules(service),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   mirrorTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		stats:    stats,
+		logger:   log,
+		inFlight: make(chan struct{}, mirrorMaxInFlight),
+	}, nil
+}
+
+// wrap mirrors the sampled requests passing through next. A nil mirror
+// returns next unchanged.
+func (m *shadowMirror) wrap(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.IntN(100) >= m.percent || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep what the client sent before the proxy rewrites the request
+		req := r.Clone(context.Background())
+		var body *mirrorBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &mirrorBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		next.ServeHTTP(w, r)
+
+		if body != nil {
+			if !body.complete() {
+				// The upstream did not read the whole body, or it was too
+				// large to keep
+				m.stats.mirrorDropped.Add(1)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body.buf.Bytes()))
+			req.ContentLength = int64(body.buf.Len())
+		}
+		m.send(req)
+	})
+}
+
+// send dispatches a mirrored request in the background, dropping it if too
+// many are already waiting on the shadow upstream
+func (m *shadowMirror) send(req *http.Request) {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		m.stats.mirrorDropped.Add(1)
+		return
+	}
+
+	go func() {
+		defer func() { <-m.inFlight }()
+
+		out := m.outgoing(req)
+		resp, err := m.client.Do(out)
+		if err != nil {
+			m.stats.mirrorErrors.Add(1)
+			m.logger.Debug("[Proxy] %s -> shadow request %s %s failed: %v", m.name, req.Method, req.URL.Path, err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		m.stats.mirrored.Add(1)
+	}()
+}
+
+// outgoing turns a copy of a client request into the request sent to the
+// shadow upstream, with the same forwarded headers and rules as the primary
+func (m *shadowMirror) outgoing(req *http.Request) *http.Request {
+	out := req.WithContext(context.Background())
+	out.RequestURI = ""
+	out.URL.Scheme = m.scheme
+	out.URL.Host = m.addr
+	out.Host = m.addr
+	removeHopHeaders(out.Header)
+
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		if req.TLS != nil {
+			out.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			out.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	if out.Header.Get("X-Forwarded-For") == "" {
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			out.Header.Set("X-Forwarded-For", ip)
+		}
+	}
+
+	if len(m.rules.request) > 0 {
+		applyHeaderRules(out.Header, m.rules.request, headerVars(req))
+	}
+	return out
+}
+
+// hopHeaders are the connection-level headers that are not forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop headers of h, including those
+// listed in its Connection header
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range bytes.Split([]byte(value), []byte(",")) {
+			if name := string(bytes.TrimSpace(name)); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// mirrorBody keeps a copy of a request body as the primary upstream reads it
+type mirrorBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	overflow bool
+	eof      bool
+}
+
+// Read implements io.Reader
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > mirrorBodyLimit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// complete reports whether the whole body was read and kept
+func (b *mirrorBody) complete() bool {
+	return b.eof && !b.overflow
+}
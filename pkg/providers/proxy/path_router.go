@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pathRoute is one path prefix of a service mapped to its own upstream
+type pathRoute struct {
+	prefix      string
+	stripPrefix bool
+	handler     http.Handler
+}
+
+// matches reports whether path falls under the route prefix on a segment boundary
+func (r pathRoute) matches(path string) bool {
+	if !strings.HasPrefix(path, r.prefix) {
+		return false
+	}
+	return len(path) == len(r.prefix) || path[len(r.prefix)] == '/'
+}
+
+// pathRouter dispatches requests to the longest matching path route, or to
+// the service's main upstream when none match
+type pathRouter struct {
+	routes   []pathRoute // sorted by descending prefix length
+	fallback http.Handler
+}
+
+// ServeHTTP implements http.Handler
+func (pr *pathRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range pr.routes {
+		if !route.matches(r.URL.Path) {
+			continue
+		}
+
+		if route.stripPrefix {
+			r = stripPathPrefix(r, route.prefix)
+		}
+		route.handler.ServeHTTP(w, r)
+		return
+	}
+
+	pr.fallback.ServeHTTP(w, r)
+}
+
+// stripPathPrefix returns a shallow copy of r with prefix removed from its
+// path and recorded in X-Forwarded-Prefix
+func stripPathPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.Header = r.Header.Clone()
+
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if !strings.HasPrefix(r2.URL.Path, "/") {
+		r2.URL.Path = "/" + r2.URL.Path
+	}
+	if r.URL.RawPath != "" {
+		rawPath := strings.TrimPrefix(r.URL.RawPath, prefix)
+		if !strings.HasPrefix(rawPath, "/") {
+			rawPath = "/" + rawPath
+		}
+		r2.URL.RawPath = rawPath
+	}
+
+	r2.Header.Set("X-Forwarded-Prefix", prefix)
+	return r2
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// routeRecorder is a route handler recording the request it was given
+type routeRecorder struct {
+	name    string
+	path    string
+	rawPath string
+	prefix  string
+}
+
+func (rr *routeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.path = r.URL.Path
+	rr.rawPath = r.URL.RawPath
+	rr.prefix = r.Header.Get("X-Forwarded-Prefix")
+	w.Header().Set("X-Route", rr.name)
+}
+
+func TestPathRouter(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantRoute  string
+		wantPath   string
+		wantRaw    string
+		wantPrefix string
+	}{
+		{target: "/", wantRoute: "main", wantPath: "/"},
+		{target: "/api", wantRoute: "api", wantPath: "/", wantPrefix: "/api"},
+		{target: "/api/users", wantRoute: "api", wantPath: "/users", wantPrefix: "/api"},
+		{target: "/apiary", wantRoute: "main", wantPath: "/apiary"},
+		{target: "/api/v2/items", wantRoute: "v2", wantPath: "/api/v2/items"},
+		{target: "/api/v2x", wantRoute: "api", wantPath: "/v2x", wantPrefix: "/api"},
+		{target: "/api/a%2Fb", wantRoute: "api", wantPath: "/a/b", wantRaw: "/a%2Fb", wantPrefix: "/api"},
+		{target: "/static/app.js", wantRoute: "static", wantPath: "/app.js", wantPrefix: "/static"},
+	}
+
+	routes := map[string]*routeRecorder{}
+	recorder := func(name string) *routeRecorder {
+		routes[name] = &routeRecorder{name: name}
+		return routes[name]
+	}
+	router := &pathRouter{
+		routes: []pathRoute{
+			{prefix: "/api", stripPrefix: true, handler: recorder("api")},
+			{prefix: "/api/v2", handler: recorder("v2")},
+			{prefix: "/static", stripPrefix: true, handler: recorder("static")},
+		},
+		fallback: recorder("main"),
+	}
+	// Longest prefix first, as the service handler orders them
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://app.example.com"+tt.target, nil)
+			w := httptest.NewRecorder()
+			original := req.URL.Path
+			router.ServeHTTP(w, req)
+
+			route := routes[w.Header().Get("X-Route")]
+			if route == nil || route.name != tt.wantRoute {
+				t.Fatalf("served by %q, want %q", w.Header().Get("X-Route"), tt.wantRoute)
+			}
+			if route.path != tt.wantPath || route.rawPath != tt.wantRaw || route.prefix != tt.wantPrefix {
+				t.Fatalf("path %q raw %q prefix %q, want %q %q %q",
+					route.path, route.rawPath, route.prefix, tt.wantPath, tt.wantRaw, tt.wantPrefix)
+			}
+			if req.URL.Path != original || req.Header.Get("X-Forwarded-Prefix") != "" {
+				t.Fatal("stripping the prefix changed the original request")
+			}
+		})
+	}
+}
@@ -3,13 +3,13 @@ package proxy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"maps"
 	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +22,7 @@ import (
 	"github.com/tphan267/arqut-edge-ce/pkg/signaling"
 	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
 	"github.com/tphan267/arqut-edge-ce/pkg/utils"
+	"gorm.io/gorm"
 )
 
 // Message type constants for proxy service sync
@@ -39,21 +40,57 @@ type SyncCallback struct {
 	retryCount int
 }
 
+// tunnelServer is a listener serving one proxy service on one interface address
+type tunnelServer interface {
+	Address() string
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// httpTunnelServer adapts http.Server to the tunnelServer interface
+type httpTunnelServer struct {
+	*http.Server
+}
+
+// Address returns the address the server listens on
+func (s httpTunnelServer) Address() string {
+	return s.Addr
+}
+
 // ProxyProvider implements Provider using HTTP reverse proxy
 type ProxyProvider struct {
 	cfg        *config.Config
 	repo       *repositories.ServiceRepository
+	ca         *localCA
+	accessLog  *accessLogWriter
 	logger     *logger.Logger
 	interfaces map[string]string // interface name -> IP
-	servers    map[string]*http.Server
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	mu         sync.RWMutex
-	portRange  struct {
+	servers    map[string]tunnelServer
+	handlers   map[string]*swappableHandler // service ID -> HTTP handler shared by its listeners
+	bindings   map[string]serviceBinding    // service ID -> what its listeners are bound with
+	hostnames  map[string]string            // virtual hostname -> service ID
+	stats      map[string]*serviceStats     // service ID -> traffic counters
+	balancers  map[string]*balancer         // service ID -> upstream load balancer
+	breakers   map[string]*circuitBreaker   // service ID -> upstream circuit breaker
+	limiters   map[string]*clientLimiter    // service ID -> per-client limits
+	captures   map[string]*captureBuffer    // service ID -> request inspector
+	caches     map[string]*responseCache    // service ID -> response cache
+	cacheDir   string
+
+	scheduleStates map[string]bool             // service ID -> whether its schedule was active on the last run
+	lifetimes      map[string]*serviceLifetime // service ID -> expiry of a temporary service
+	expireMu       sync.Mutex                  // serializes expiring services
+
+	healthCheckers map[string]*healthChecker // service ID -> active health checks
+	ctx            context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	mu             sync.RWMutex
+	portRange      struct {
 		start int
 		end   int
 	}
+	reservedPorts   map[int]bool // never allocated automatically
 	pingServer      *http.Server
 	shutdownTimeout time.Duration
 	started         bool
@@ -66,7 +103,19 @@ type ProxyProvider struct {
 func NewProxyProvider() *ProxyProvider {
 	proxy := &ProxyProvider{
 		interfaces:      make(map[string]string),
-		servers:         make(map[string]*http.Server),
+		servers:         make(map[string]tunnelServer),
+		handlers:        make(map[string]*swappableHandler),
+		bindings:        make(map[string]serviceBinding),
+		hostnames:       make(map[string]string),
+		balancers:       make(map[string]*balancer),
+		breakers:        make(map[string]*circuitBreaker),
+		limiters:        make(map[string]*clientLimiter),
+		captures:        make(map[string]*captureBuffer),
+		caches:          make(map[string]*responseCache),
+		scheduleStates:  make(map[string]bool),
+		lifetimes:       make(map[string]*serviceLifetime),
+		healthCheckers:  make(map[string]*healthChecker),
+		stats:           make(map[string]*serviceStats),
 		shutdownTimeout: 30 * time.Second,
 		started:         false,
 		syncCallbacks:   make(map[string]SyncCallback),
@@ -97,6 +146,27 @@ func (p *ProxyProvider) Initialize(ctx context.Context, registry *providers.Regi
 	p.repo = registry.DB().ServiceRepo()
 	p.logger = registry.Logger()
 
+	if cfg.TunnelPortStart != 0 || cfg.TunnelPortEnd != 0 {
+		if cfg.TunnelPortStart < 1 || cfg.TunnelPortEnd > 65535 || cfg.TunnelPortStart > cfg.TunnelPortEnd {
+			return fmt.Errorf("invalid tunnel port range %d-%d", cfg.TunnelPortStart, cfg.TunnelPortEnd)
+		}
+		p.SetPortRange(cfg.TunnelPortStart, cfg.TunnelPortEnd)
+	}
+	reserved, err := utils.ParsePortList(cfg.ReservedTunnelPorts)
+	if err != nil {
+		return fmt.Errorf("invalid reserved tunnel ports: %w", err)
+	}
+	p.SetReservedPorts(reserved)
+
+	ca, err := newLocalCA(registry.DB().CertRepo(), cfg.EdgeID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize certificate authority: %w", err)
+	}
+	p.ca = ca
+
+	p.accessLog = newAccessLogWriter(registry.DB().AccessLogRepo(), cfg, p.logger)
+	p.cacheDir = filepath.Join(filepath.Dir(cfg.DBPath), "cache")
+
 	// Expose UI as service if no services exist
 	if err := p.ExposeUIAsService(); err != nil {
 		return fmt.Errorf("failed to expose UI as service: %w", err)
@@ -201,6 +271,12 @@ func (p *ProxyProvider) syncAllServices(remove bool) {
 
 // syncServiceOperation sends an individual service operation to the cloud
 func (p *ProxyProvider) syncServiceOperation(operation string, service *models.ProxyService) {
+	p.queueServiceSync(operation, service, nil)
+}
+
+// queueServiceSync sends a service sync message carrying the service and any
+// extra fields to the cloud
+func (p *ProxyProvider) queueServiceSync(operation string, service *models.ProxyService, extra map[string]any) {
 	p.mu.RLock()
 	syncChan := p.syncChan
 	p.mu.RUnlock()
@@ -229,6 +305,7 @@ func (p *ProxyProvider) syncServiceOperation(operation string, service *models.P
 		"operation":  operation,
 		"service":    service,
 	}
+	maps.Copy(data, extra)
 
 	// Send to outbound channel (non-blocking)
 	select {
@@ -306,7 +383,19 @@ func (p *ProxyProvider) SetPortRange(start, end int) {
 	p.portRange.end = end
 }
 
-// allocatePort finds an available port in the configured range
+// SetReservedPorts sets the ports that are never allocated automatically.
+// Services can still request them explicitly.
+func (p *ProxyProvider) SetReservedPorts(ports []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reservedPorts = make(map[int]bool, len(ports))
+	for _, port := range ports {
+		p.reservedPorts[port] = true
+	}
+}
+
+// allocatePort finds an available port in the configured range, skipping
+// reserved ports
 func (p *ProxyProvider) allocatePort() (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -322,7 +411,7 @@ func (p *ProxyProvider) allocatePort() (int, error) {
 	}
 
 	for port := p.portRange.start; port <= p.portRange.end; port++ {
-		if !usedPortMap[port] {
+		if !usedPortMap[port] && !p.reservedPorts[port] {
 			// Verify port is actually available on the system
 			if ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
 				ln.Close()
@@ -334,6 +423,45 @@ func (p *ProxyProvider) allocatePort() (int, error) {
 	return 0, fmt.Errorf("no available ports in range %d-%d", p.portRange.start, p.portRange.end)
 }
 
+// checkTunnelPort verifies that a requested tunnel port is inside the
+// configured range, not taken by another service and free on the system.
+// serviceID is the service asking for the port, empty for a new service.
+// Unusable ports are reported as a repositories.ValidationError.
+func (p *ProxyProvider) checkTunnelPort(port int, serviceID string) error {
+	p.mu.RLock()
+	start, end := p.portRange.start, p.portRange.end
+	p.mu.RUnlock()
+
+	if port < start || port > end {
+		return &repositories.ValidationError{
+			Err: fmt.Errorf("tunnel port %d is outside the allowed range %d-%d", port, start, end),
+		}
+	}
+
+	owner, err := p.repo.GetServiceByTunnelPort(port)
+	if err == nil {
+		if owner.ID == serviceID {
+			// Unchanged
+			return nil
+		}
+		return &repositories.ValidationError{
+			Err: fmt.Errorf("tunnel port %d is already used by service %s", port, owner.Name),
+		}
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to check tunnel port: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return &repositories.ValidationError{
+			Err: fmt.Errorf("tunnel port %d is not available: %w", port, err),
+		}
+	}
+	ln.Close()
+	return nil
+}
+
 // Start starts the proxy service
 func (p *ProxyProvider) Start(ctx context.Context) error {
 	p.mu.Lock()
@@ -348,6 +476,20 @@ func (p *ProxyProvider) Start(ctx context.Context) error {
 	p.started = true
 	p.mu.Unlock()
 
+	if p.accessLog != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.accessLog.run(childCtx)
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.runScheduler(childCtx)
+	}()
+
 	// Start ping service on port 3031 (non-critical, log error but don't fail)
 	if err := p.startPingService(childCtx, 3031); err != nil {
 		p.logger.Printf("Warning: Ping service on port 3031 failed to start: %v", err)
@@ -380,6 +522,15 @@ func (p *ProxyProvider) Start(ctx context.Context) error {
 		p.logger.Printf("Some services failed to start: %d errors", len(startErrors))
 	}
 
+	p.mu.RLock()
+	interfaces := make(map[string]string)
+	maps.Copy(interfaces, p.interfaces)
+	p.mu.RUnlock()
+
+	for _, ip := range interfaces {
+		p.startVHostListeners(childCtx, ip)
+	}
+
 	return nil
 }
 
@@ -531,11 +682,23 @@ func (p *ProxyProvider) ExposeUIAsService() error {
 }
 
 // AddService creates a new proxy service
-func (p *ProxyProvider) AddService(name, localHost string, localPort int, protocol string) (*models.ProxyService, error) {
-	// Allocate tunnel port
-	tunnelPort, err := p.allocatePort()
-	if err != nil {
-		return nil, fmt.Errorf("failed to allocate port: %w", err)
+func (p *ProxyProvider) AddService(name, localHost string, localPort int, protocol string, options ...models.ProxyServiceConfig) (*models.ProxyService, error) {
+	// Use the requested tunnel port, or allocate one
+	var tunnelPort int
+	for _, config := range options {
+		if config.TunnelPort != nil {
+			tunnelPort = *config.TunnelPort
+		}
+	}
+	if tunnelPort != 0 {
+		if err := p.checkTunnelPort(tunnelPort, ""); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if tunnelPort, err = p.allocatePort(); err != nil {
+			return nil, fmt.Errorf("failed to allocate port: %w", err)
+		}
 	}
 
 	service, err := p.repo.AddService(name, localHost, localPort, tunnelPort, protocol)
@@ -543,6 +706,21 @@ func (p *ProxyProvider) AddService(name, localHost string, localPort int, protoc
 		return nil, fmt.Errorf("failed to add service: %w", err)
 	}
 
+	// Settings given at creation are applied before the service first starts
+	for _, config := range options {
+		if err := p.repo.UpdateService(service.ID, config); err != nil {
+			if delErr := p.repo.DeleteService(service.ID); delErr != nil {
+				p.logger.Printf("[Proxy] Failed to remove service %s after invalid settings: %v", service.ID, delErr)
+			}
+			return nil, fmt.Errorf("failed to add service: %w", err)
+		}
+	}
+	if len(options) > 0 {
+		if service, err = p.repo.GetService(service.ID); err != nil {
+			return nil, fmt.Errorf("failed to add service: %w", err)
+		}
+	}
+
 	// Start service if proxy is running
 	p.mu.RLock()
 	started := p.started
@@ -563,12 +741,23 @@ func (p *ProxyProvider) AddService(name, localHost string, localPort int, protoc
 
 // ModifyService updates a proxy service
 func (p *ProxyProvider) ModifyService(id string, config models.ProxyServiceConfig, operations ...string) error {
+	if config.TunnelPort != nil {
+		if err := p.checkTunnelPort(*config.TunnelPort, id); err != nil {
+			return fmt.Errorf("failed to update service: %w", err)
+		}
+	}
+
 	err := p.repo.UpdateService(id, config)
 	if err != nil {
 		return fmt.Errorf("failed to update service: %w", err)
 	}
 
-	p.restartService(id)
+	if !limitsOnly(config) {
+		p.reloadService(id)
+	}
+	if config.Schedules != nil || config.ScheduleTimezone != nil {
+		p.resetSchedule(id)
+	}
 
 	// Get updated service for sync
 	service, err := p.repo.GetService(id)
@@ -577,6 +766,11 @@ func (p *ProxyProvider) ModifyService(id string, config models.ProxyServiceConfi
 		return nil // Don't fail the modify operation
 	}
 
+	if limitsOnly(config) {
+		// Running listeners pick up new limits on their next request
+		p.limiterFor(service)
+	}
+
 	// Trigger sync after successful modify
 	operation := "updated"
 	if len(operations) > 0 {
@@ -613,6 +807,22 @@ func (p *ProxyProvider) DeleteService(id string) error {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
 
+	p.mu.Lock()
+	delete(p.stats, id)
+	delete(p.limiters, id)
+	delete(p.captures, id)
+	delete(p.scheduleStates, id)
+	delete(p.lifetimes, id)
+	p.mu.Unlock()
+
+	p.dropCache(id)
+
+	if p.accessLog != nil {
+		if err := p.accessLog.repo.DeleteServiceEntries(id); err != nil {
+			p.logger.Printf("Failed to delete access log of service %s: %v", service.Name, err)
+		}
+	}
+
 	// Trigger sync after successful delete
 	p.syncServiceOperation("deleted", service)
 
@@ -654,11 +864,18 @@ func (p *ProxyProvider) startService(ctx context.Context, service *models.ProxyS
 	maps.Copy(interfaces, p.interfaces)
 	p.mu.RUnlock()
 
+	p.mu.Lock()
+	p.bindings[service.ID] = bindingOf(service)
+	p.mu.Unlock()
+
+	p.registerHostname(service)
+	p.startHealthCheck(ctx, service)
+
 	var startErrors []error
 	for _, ip := range interfaces {
 		addr := fmt.Sprintf("%s:%d", ip, service.TunnelPort)
 
-		if err := p.startReverseProxyService(ctx, service, addr); err != nil {
+		if err := p.startServiceOnAddr(ctx, service, addr); err != nil {
 			startErrors = append(startErrors, fmt.Errorf("failed to start %s service %s on %s: %w",
 				strings.ToUpper(service.Protocol), service.Name, addr, err))
 		}
@@ -674,67 +891,72 @@ func (p *ProxyProvider) startService(ctx context.Context, service *models.ProxyS
 	return nil
 }
 
-// startReverseProxyService starts a reverse proxy on a specific address
-func (p *ProxyProvider) startReverseProxyService(ctx context.Context, service *models.ProxyService, addr string) error {
-	scheme := "http"
-	if strings.ToLower(service.Protocol) == "websocket" {
-		scheme = "http" // WebSocket upgrades start as HTTP
+// startServiceOnAddr starts a proxy service on a specific address using the
+// listener matching its protocol
+func (p *ProxyProvider) startServiceOnAddr(ctx context.Context, service *models.ProxyService, addr string) error {
+	switch strings.ToLower(service.Protocol) {
+	case models.ProtocolTCP:
+		return p.startTCPForwardService(ctx, service, addr)
+	case models.ProtocolUDP:
+		return p.startUDPForwardService(ctx, service, addr)
+	default:
+		return p.startReverseProxyService(ctx, service, addr)
 	}
+}
 
-	target, err := url.Parse(fmt.Sprintf("%s://%s:%d", scheme, service.LocalHost, service.LocalPort))
+// startReverseProxyService starts a reverse proxy on a specific address
+func (p *ProxyProvider) startReverseProxyService(ctx context.Context, service *models.ProxyService, addr string) error {
+	handler, err := p.serviceHandler(service)
 	if err != nil {
-		return fmt.Errorf("failed to parse target URL: %w", err)
+		return err
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(target)
-
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		// Log incoming request
-		p.logger.Printf("[Proxy] %s -> %s %s%s", service.Name, req.Method, req.Host, req.URL.RequestURI())
-
-		originalDirector(req)
-
-		// Set the Host header to the target host (required for HA and other apps that check Host)
-		req.Host = target.Host
+	// Read and write deadlines are applied per request by the handler
+	timeouts := resolveTimeouts(service)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.readHeader,
+		IdleTimeout:       timeouts.idle,
+		ConnContext:       withConnUses,
+	}
 
-		// Add forwarded headers
-		if req.Header.Get("X-Forwarded-Proto") == "" {
-			req.Header.Set("X-Forwarded-Proto", "http")
-		}
-		if req.Header.Get("X-Forwarded-For") == "" {
-			if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-				req.Header.Set("X-Forwarded-For", clientIP)
-			}
+	if service.TLSEnabled {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("failed to parse listen address: %w", err)
 		}
+		// Look the hostname up on each handshake so a renamed host is
+		// picked up without rebinding
+		server.TLSConfig = p.serverTLSConfig(host, func(name string) bool {
+			return p.servesHostname(name, service.ID)
+		})
 	}
 
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		p.logger.Printf("Proxy error for service %s: %v", service.Name, err)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
-	}
+	p.registerServer(service.ID, addr, httpTunnelServer{server})
 
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      proxy,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
+	p.logger.Printf("Starting %s proxy service %s on %s -> %s",
+		strings.ToUpper(service.Protocol), service.Name, addr, p.balancerFor(service).describe())
+	p.runHTTPServer(ctx, server, fmt.Sprintf("%s proxy service %s", strings.ToUpper(service.Protocol), service.Name))
 
-	key := fmt.Sprintf("%s-%s", service.ID, addr)
-	p.mu.Lock()
-	p.servers[key] = server
-	p.mu.Unlock()
+	return nil
+}
 
+// runHTTPServer serves a tunnel HTTP server in the background and shuts it
+// down when ctx is cancelled
+func (p *ProxyProvider) runHTTPServer(ctx context.Context, server *http.Server, label string) {
 	p.wg.Add(2)
 
 	go func() {
 		defer p.wg.Done()
-		p.logger.Printf("Starting %s proxy service %s on %s -> %s:%d",
-			strings.ToUpper(service.Protocol), service.Name, addr, service.LocalHost, service.LocalPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			p.logger.Printf("Proxy server error for %s: %v", service.Name, err)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			p.logger.Printf("Proxy server error for %s: %v", label, err)
 		}
 	}()
 
@@ -746,67 +968,38 @@ func (p *ProxyProvider) startReverseProxyService(ctx context.Context, service *m
 		defer cancel()
 
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			p.logger.Printf("Force closing server for %s: %v", service.Name, err)
+			p.logger.Printf("Force closing server for %s: %v", label, err)
 			server.Close()
 		}
 
-		p.logger.Printf("Stopped %s proxy service %s on %s",
-			strings.ToUpper(service.Protocol), service.Name, addr)
+		p.logger.Printf("Stopped %s on %s", label, server.Addr)
 	}()
-
-	return nil
 }
 
-// restartService restarts a proxy service
-func (p *ProxyProvider) restartService(id string) {
-	p.stopService(id)
-
-	service, err := p.repo.GetService(id)
-	if err != nil {
-		p.logger.Printf("Failed to get service %s for restart: %v", id, err)
-		return
-	}
-
-	p.mu.RLock()
-	started := p.started
-	ctx := p.ctx
-	p.mu.RUnlock()
-
-	if service.Enabled && started && ctx != nil {
-		if err := p.startService(ctx, service); err != nil {
-			p.logger.Printf("Failed to restart service %s: %v", id, err)
-		}
-	}
+// registerServer tracks a running listener so it can be stopped per service or interface
+func (p *ProxyProvider) registerServer(serviceID, addr string, server tunnelServer) {
+	key := fmt.Sprintf("%s-%s", serviceID, addr)
+	p.mu.Lock()
+	p.servers[key] = server
+	p.mu.Unlock()
 }
 
 // stopService stops a proxy service
 func (p *ProxyProvider) stopService(id string) {
-	p.mu.Lock()
-	var serversToShutdown []*http.Server
-	keysToDelete := []string{}
+	servers := p.takeServers(id)
 
-	for key, server := range p.servers {
-		if strings.HasPrefix(key, id+"-") {
-			serversToShutdown = append(serversToShutdown, server)
-			keysToDelete = append(keysToDelete, key)
-		}
-	}
-
-	for _, key := range keysToDelete {
-		delete(p.servers, key)
-	}
+	p.mu.Lock()
+	delete(p.handlers, id)
+	delete(p.bindings, id)
 	p.mu.Unlock()
 
-	for _, server := range serversToShutdown {
-		p.logger.Printf("Stopping server for service %s on %s", id, server.Addr)
+	p.takeBalancers(id)
+	p.dropBreakers(id, nil)
 
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			p.logger.Printf("Graceful shutdown failed for %s, forcing close: %v", server.Addr, err)
-			server.Close()
-		}
-		cancel()
-	}
+	p.unregisterHostnames(id)
+	p.stopHealthCheck(id)
+
+	p.shutdownServers(id, servers, 5*time.Second)
 }
 
 // startServicesOnInterface starts all services on a new interface
@@ -829,21 +1022,23 @@ func (p *ProxyProvider) startServicesOnInterface(ip string) {
 	for _, service := range services {
 		if service.Enabled {
 			addr := fmt.Sprintf("%s:%d", ip, service.TunnelPort)
-			if err := p.startReverseProxyService(ctx, service, addr); err != nil {
+			if err := p.startServiceOnAddr(ctx, service, addr); err != nil {
 				p.logger.Printf("Failed to start service %s on new interface %s: %v", service.Name, ip, err)
 			}
 		}
 	}
+
+	p.startVHostListeners(ctx, ip)
 }
 
 // stopServicesOnInterface stops all services on a removed interface
 func (p *ProxyProvider) stopServicesOnInterface(ip string) {
 	p.mu.Lock()
-	var serversToShutdown []*http.Server
+	var serversToShutdown []tunnelServer
 	keysToDelete := []string{}
 
 	for key, server := range p.servers {
-		host, _, err := net.SplitHostPort(server.Addr)
+		host, _, err := net.SplitHostPort(server.Address())
 		if err == nil && host == ip {
 			serversToShutdown = append(serversToShutdown, server)
 			keysToDelete = append(keysToDelete, key)
@@ -856,11 +1051,11 @@ func (p *ProxyProvider) stopServicesOnInterface(ip string) {
 	p.mu.Unlock()
 
 	for _, server := range serversToShutdown {
-		p.logger.Printf("Stopping server on removed interface %s: %s", ip, server.Addr)
+		p.logger.Printf("Stopping server on removed interface %s: %s", ip, server.Address())
 
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			p.logger.Printf("Graceful shutdown failed for %s, forcing close: %v", server.Addr, err)
+			p.logger.Printf("Graceful shutdown failed for %s, forcing close: %v", server.Address(), err)
 			server.Close()
 		}
 		cancel()
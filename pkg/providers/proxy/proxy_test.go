@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestLogger returns a logger that discards its output
+func newTestLogger() *logger.Logger {
+	return logger.New(io.Discard, "", logger.ErrorLevel)
+}
+
+// newTestRepoProvider returns a provider storing services in a fresh database
+func newTestRepoProvider(t *testing.T) *ProxyProvider {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	p.repo = repositories.NewServiceRepository(db)
+	return p
+}
+
+// freePortRange returns the first of n consecutive ports free on the system
+func freePortRange(t *testing.T, n int) int {
+	t.Helper()
+
+	for range 100 {
+		start := 40000 + rand.N(20000)
+		free := true
+		for port := start; port < start+n && free; port++ {
+			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				free = false
+				continue
+			}
+			ln.Close()
+		}
+		if free {
+			return start
+		}
+	}
+	t.Fatalf("no %d consecutive free ports", n)
+	return 0
+}
+
+func TestAllocatePort(t *testing.T) {
+	p := newTestRepoProvider(t)
+	start := freePortRange(t, 4)
+	p.SetPortRange(start, start+3)
+	p.SetReservedPorts([]int{start, start + 2})
+
+	if _, err := p.repo.AddService("used", "127.0.0.1", 8080, start+1, models.ProtocolHTTP); err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := p.allocatePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != start+3 {
+		t.Fatalf("allocated %d, want %d past the used and reserved ports", port, start+3)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", start+3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if port, err := p.allocatePort(); err == nil {
+		t.Fatalf("allocated %d with every port used, reserved or bound", port)
+	}
+}
+
+func TestCheckTunnelPort(t *testing.T) {
+	p := newTestRepoProvider(t)
+	start := freePortRange(t, 4)
+	p.SetPortRange(start, start+3)
+	p.SetReservedPorts([]int{start + 2})
+
+	owner, err := p.repo.AddService("owner", "127.0.0.1", 8080, start, models.ProtocolHTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", start+3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tests := []struct {
+		name      string
+		port      int
+		serviceID string
+		wantErr   string
+	}{
+		{name: "free", port: start + 1},
+		{name: "reserved ports can be requested", port: start + 2},
+		{name: "owner keeps its port", port: start, serviceID: owner.ID},
+		{name: "taken by another service", port: start, wantErr: "already used by service owner"},
+		{name: "bound on the system", port: start + 3, wantErr: "not available"},
+		{name: "below the range", port: start - 1, wantErr: "outside the allowed range"},
+		{name: "above the range", port: start + 4, wantErr: "outside the allowed range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.checkTunnelPort(tt.port, tt.serviceID)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("checkTunnelPort: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("checkTunnelPort error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
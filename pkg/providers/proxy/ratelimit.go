@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// limiterSweepInterval is how often idle client entries are dropped
+const limiterSweepInterval = time.Minute
+
+// errClientLimited is returned for new sessions of a client over its limits
+var errClientLimited = errors.New("client limit reached")
+
+// clientBucket is the token bucket and connection count of one client IP
+type clientBucket struct {
+	tokens float64
+	last   time.Time
+	conns  int
+}
+
+// clientLimiter enforces a service's per-client request rate and concurrent
+// connection limits. Limits are read on every request, so they can be changed
+// while the service keeps serving.
+type clientLimiter struct {
+	rate     float64 // tokens per second, 0 disables rate limiting
+	burst    float64
+	maxConns int // 0 disables the connection limit
+
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// newClientLimiter returns a limiter with no limits set
+func newClientLimiter() *clientLimiter {
+	return &clientLimiter{
+		clients:   make(map[string]*clientBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// setLimits applies a service's limit settings
+func (l *clientLimiter) setLimits(service *models.ProxyService) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(service.RateLimit)
+	l.burst = float64(service.RateBurst)
+	if l.burst < l.rate {
+		l.burst = math.Max(l.rate, 1)
+	}
+	l.maxConns = service.ConnLimit
+}
+
+// enabled reports whether any limit is set
+func (l *clientLimiter) enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0 || l.maxConns > 0
+}
+
+// bucket returns the entry for a client, creating it with a full bucket.
+// The caller must hold l.mu.
+func (l *clientLimiter) bucket(ip string, now time.Time) *clientBucket {
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, exists := l.clients[ip]
+	if !exists {
+		b = &clientBucket{tokens: l.burst, last: now}
+		l.clients[ip] = b
+	}
+	return b
+}
+
+// sweep drops clients with no open connections whose bucket has refilled.
+// The caller must hold l.mu.
+func (l *clientLimiter) sweep(now time.Time) {
+	for ip, b := range l.clients {
+		if b.conns == 0 && (l.rate == 0 || b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst) {
+			delete(l.clients, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// allow takes a token for a client, returning how long to wait when none is
+// left
+func (l *clientLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	b := l.bucket(ip, now)
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// acquire opens a connection slot for a client, failing when it already has
+// the maximum number open
+func (l *clientLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(ip, time.Now())
+	if l.maxConns > 0 && b.conns >= l.maxConns {
+		return false
+	}
+	b.conns++
+	return true
+}
+
+// release closes a connection slot opened with acquire
+func (l *clientLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, exists := l.clients[ip]; exists && b.conns > 0 {
+		b.conns--
+	}
+}
+
+// admit applies both limits to a new request or connection. On success the
+// caller must release the client's connection slot when done.
+func (l *clientLimiter) admit(ip string) (bool, time.Duration) {
+	if ok, wait := l.allow(ip); !ok {
+		return false, wait
+	}
+	if !l.acquire(ip) {
+		return false, time.Second
+	}
+	return true, 0
+}
+
+// limiterFor returns the limiter of a service with its current settings
+// applied, creating it on first use
+func (p *ProxyProvider) limiterFor(service *models.ProxyService) *clientLimiter {
+	p.mu.Lock()
+	l, exists := p.limiters[service.ID]
+	if !exists {
+		l = newClientLimiter()
+		p.limiters[service.ID] = l
+	}
+	p.mu.Unlock()
+
+	l.setLimits(service)
+	return l
+}
+
+// rateLimit rejects requests over a service's per-client limits with 429
+func (p *ProxyProvider) rateLimit(service *models.ProxyService, next http.Handler) http.Handler {
+	l := p.limiterFor(service)
+	stats := p.statsFor(service.ID)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r.RemoteAddr)
+		ok, wait := l.admit(ip)
+		if !ok {
+			stats.limited.Add(1)
+			p.logger.Debug("[Proxy] %s -> rate limited client %s", service.Name, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(ip)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP part of a remote address
+func clientIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// limitsOnly reports whether an update changes nothing but the rate and
+// connection limits, which are applied without restarting the listeners
+func limitsOnly(config models.ProxyServiceConfig) bool {
+	if config.RateLimit == nil && config.RateBurst == nil && config.ConnLimit == nil {
+		return false
+	}
+
+	rest := config
+	rest.RateLimit = nil
+	rest.RateBurst = nil
+	rest.ConnLimit = nil
+	return reflect.ValueOf(rest).IsZero()
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestLimiter returns a limiter with the given service limits
+func newTestLimiter(rate, burst, conns int) *clientLimiter {
+	l := newClientLimiter()
+	l.setLimits(&models.ProxyService{RateLimit: rate, RateBurst: burst, ConnLimit: conns})
+	return l
+}
+
+func TestClientLimiterTokenBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    int
+		burst   int
+		elapsed time.Duration // time since the bucket was last drained
+		want    []bool        // results of consecutive allow calls
+	}{
+		{name: "unlimited", rate: 0, want: []bool{true, true, true, true}},
+		{name: "burst defaults to rate", rate: 2, want: []bool{true, true, false}},
+		{name: "burst above rate", rate: 1, burst: 3, want: []bool{true, true, true, false}},
+		{name: "burst below rate is raised", rate: 3, burst: 1, want: []bool{true, true, true, false}},
+		{name: "refills over time", rate: 2, elapsed: time.Second, want: []bool{true, true, false}},
+		{name: "partial refill", rate: 2, elapsed: 600 * time.Millisecond, want: []bool{true, false}},
+		{name: "refill is capped at burst", rate: 1, burst: 2, elapsed: time.Hour, want: []bool{true, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(tt.rate, tt.burst, 0)
+			if tt.elapsed > 0 {
+				// Start from an empty bucket drained tt.elapsed ago
+				l.clients["10.0.0.1"] = &clientBucket{last: time.Now().Add(-tt.elapsed)}
+			}
+
+			for i, want := range tt.want {
+				ok, wait := l.allow("10.0.0.1")
+				if ok != want {
+					t.Fatalf("allow #%d = %v, want %v", i+1, ok, want)
+				}
+				if !ok && wait <= 0 {
+					t.Fatalf("allow #%d rejected with wait %s, want a positive wait", i+1, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestClientLimiterBucketsArePerClient(t *testing.T) {
+	l := newTestLimiter(1, 1, 0)
+
+	if ok, _ := l.allow("10.0.0.1"); !ok {
+		t.Fatal("first request of 10.0.0.1 rejected")
+	}
+	if ok, _ := l.allow("10.0.0.1"); ok {
+		t.Fatal("second request of 10.0.0.1 allowed")
+	}
+	if ok, _ := l.allow("10.0.0.2"); !ok {
+		t.Fatal("first request of 10.0.0.2 rejected")
+	}
+}
+
+func TestClientLimiterConnections(t *testing.T) {
+	tests := []struct {
+		name  string
+		conns int
+		ops   string // a acquires (+ expected success, - expected failure), r releases
+	}{
+		{name: "unlimited", conns: 0, ops: "+++++"},
+		{name: "limit reached", conns: 2, ops: "++-"},
+		{name: "release frees a slot", conns: 2, ops: "++-r+-"},
+		{name: "release without a slot", conns: 1, ops: "rr+-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(0, 0, tt.conns)
+			for i, op := range tt.ops {
+				if op == 'r' {
+					l.release("10.0.0.1")
+					continue
+				}
+				if got, want := l.acquire("10.0.0.1"), op == '+'; got != want {
+					t.Fatalf("acquire at step %d = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientLimiterAdmit(t *testing.T) {
+	t.Run("rate limit", func(t *testing.T) {
+		l := newTestLimiter(1, 1, 5)
+		if ok, _ := l.admit("10.0.0.1"); !ok {
+			t.Fatal("first connection rejected")
+		}
+		ok, wait := l.admit("10.0.0.1")
+		if ok || wait <= 0 {
+			t.Fatalf("admit = %v, %s; want rejection with a wait", ok, wait)
+		}
+		if conns := l.clients["10.0.0.1"].conns; conns != 1 {
+			t.Fatalf("open connections = %d after a rate limited admit, want 1", conns)
+		}
+	})
+
+	t.Run("connection limit", func(t *testing.T) {
+		l := newTestLimiter(0, 0, 1)
+		if ok, _ := l.admit("10.0.0.1"); !ok {
+			t.Fatal("first connection rejected")
+		}
+		if ok, _ := l.admit("10.0.0.1"); ok {
+			t.Fatal("second concurrent connection admitted")
+		}
+		l.release("10.0.0.1")
+		if ok, _ := l.admit("10.0.0.1"); !ok {
+			t.Fatal("connection rejected after release")
+		}
+	})
+}
+
+func TestClientLimiterSweep(t *testing.T) {
+	l := newTestLimiter(1, 1, 1)
+	l.allow("10.0.0.1")
+	l.acquire("10.0.0.2")
+
+	// 10.0.0.1 has refilled and nothing open; 10.0.0.2 holds a connection
+	l.clients["10.0.0.1"].last = time.Now().Add(-time.Minute)
+	l.clients["10.0.0.2"].last = time.Now().Add(-time.Minute)
+	l.sweep(time.Now())
+
+	if _, exists := l.clients["10.0.0.1"]; exists {
+		t.Error("idle client kept")
+	}
+	if _, exists := l.clients["10.0.0.2"]; !exists {
+		t.Error("client with an open connection dropped")
+	}
+}
+
+func TestLimitsOnly(t *testing.T) {
+	rate, name := 5, "renamed"
+	tests := []struct {
+		name   string
+		config models.ProxyServiceConfig
+		want   bool
+	}{
+		{name: "limits", config: models.ProxyServiceConfig{RateLimit: &rate}, want: true},
+		{name: "limits and more", config: models.ProxyServiceConfig{RateLimit: &rate, Name: &name}, want: false},
+		{name: "no limits", config: models.ProxyServiceConfig{Name: &name}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitsOnly(tt.config); got != tt.want {
+				t.Fatalf("limitsOnly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUDPSessionLimits(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	port := upstream.LocalAddr().(*net.UDPAddr).Port
+
+	tests := []struct {
+		name  string
+		rate  int
+		conns int
+	}{
+		{name: "session limit", conns: 1},
+		{name: "session rate", rate: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &forwardConfig{
+				name:    "test",
+				lb:      newSingleTargetBalancer("test", "127.0.0.1", port, newTestLogger()),
+				limiter: newTestLimiter(tt.rate, 0, tt.conns),
+			}
+			f, err := newUDPForwarder("127.0.0.1:0", config, newServiceStats(), newTestLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			first := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+			second := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5001}
+			other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+
+			session, err := f.session(first, config)
+			if err != nil {
+				t.Fatalf("first session: %v", err)
+			}
+			if again, err := f.session(first, config); err != nil || again != session {
+				t.Fatalf("existing session = %p, %v; want %p", again, err, session)
+			}
+			if _, err := f.session(second, config); !errors.Is(err, errClientLimited) {
+				t.Fatalf("second session of the client: err = %v, want %v", err, errClientLimited)
+			}
+			if _, err := f.session(other, config); err != nil {
+				t.Fatalf("session of another client: %v", err)
+			}
+
+			if tt.conns > 0 {
+				f.removeSession(first.String(), session)
+				if _, err := f.session(second, config); err != nil {
+					t.Fatalf("session after the first closed: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// swappableHandler is the HTTP handler shared by the listeners of a service.
+// Reloading the service swaps the handler behind it without touching the
+// listeners; requests already in flight finish on the handler they started on.
+type swappableHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+// newSwappableHandler returns a swappable handler serving h
+func newSwappableHandler(h http.Handler) *swappableHandler {
+	s := &swappableHandler{}
+	s.swap(h)
+	return s
+}
+
+// swap replaces the handler serving new requests
+func (s *swappableHandler) swap(h http.Handler) {
+	s.current.Store(&h)
+}
+
+// ServeHTTP implements http.Handler
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
+
+// forwardConfig is the configuration of a TCP or UDP forwarder that can be
+// replaced while it runs. Each connection or session keeps the configuration
+// it was opened with.
+type forwardConfig struct {
+	name     string
+	lb       *balancer
+	access   *accessFilter
+	limiter  *clientLimiter
+	lifetime *serviceLifetime
+}
+
+// forwardConfigFor builds the forwarder configuration of a service
+func (p *ProxyProvider) forwardConfigFor(service *models.ProxyService) *forwardConfig {
+	return &forwardConfig{
+		name:     service.Name,
+		lb:       p.balancerFor(service),
+		access:   p.newAccessFilter(service),
+		limiter:  p.limiterFor(service),
+		lifetime: p.lifetimeFor(service),
+	}
+}
+
+// reconfigurable is a listener whose forwarder configuration can be replaced
+// while it runs
+type reconfigurable interface {
+	reconfigure(config *forwardConfig)
+}
+
+// serviceBinding is what the listeners of a service are bound with. A change
+// to any of it needs new listeners; everything else is swapped in place.
+type serviceBinding struct {
+	port       int
+	kind       string // models.ProtocolTCP, models.ProtocolUDP or "http"
+	tls        bool
+	readHeader time.Duration // http.Server settings, fixed once it serves
+	idle       time.Duration
+}
+
+// bindingOf returns the listener binding of a service
+func bindingOf(service *models.ProxyService) serviceBinding {
+	b := serviceBinding{port: service.TunnelPort}
+	switch protocol := strings.ToLower(service.Protocol); protocol {
+	case models.ProtocolTCP, models.ProtocolUDP:
+		b.kind = protocol
+	default:
+		timeouts := resolveTimeouts(service)
+		b.kind = "http"
+		b.tls = service.TLSEnabled
+		b.readHeader = timeouts.readHeader
+		b.idle = timeouts.idle
+	}
+	return b
+}
+
+// reloadService applies the stored configuration of a service to its
+// listeners. Running listeners keep serving with the new configuration
+// swapped in behind them, so open requests, websockets and connections are
+// not dropped. Only a change to the listener binding, such as a new tunnel
+// port, binds new listeners while the old ones drain.
+func (p *ProxyProvider) reloadService(id string) {
+	service, err := p.repo.GetService(id)
+	if err != nil {
+		p.logger.Printf("Failed to get service %s for reload: %v", id, err)
+		return
+	}
+
+	p.mu.RLock()
+	started := p.started
+	ctx := p.ctx
+	binding, bound := p.bindings[id]
+	running := false
+	for key := range p.servers {
+		if strings.HasPrefix(key, id+"-") {
+			running = true
+			break
+		}
+	}
+	p.mu.RUnlock()
+
+	switch {
+	case !service.Enabled || !started || ctx == nil:
+		p.stopService(id)
+
+	case !bound || !running:
+		p.stopService(id)
+		if err := p.startService(ctx, service); err != nil {
+			p.logger.Printf("Failed to restart service %s: %v", id, err)
+		}
+
+	case binding != bindingOf(service):
+		p.rebindService(ctx, service, binding)
+
+	default:
+		if err := p.swapService(ctx, service); err != nil {
+			p.logger.Printf("Failed to reload service %s, keeping its previous configuration: %v", service.Name, err)
+		}
+	}
+}
+
+// swapService replaces the configuration of a running service behind its
+// listeners
+func (p *ProxyProvider) swapService(ctx context.Context, service *models.ProxyService) error {
+	// Build against a fresh balancer in case the upstreams changed. Requests
+	// in flight release their targets to the balancer they came from.
+	previous := p.takeBalancers(service.ID)
+
+	p.mu.Lock()
+	handler := p.handlers[service.ID]
+	var forwarders []reconfigurable
+	for key, server := range p.servers {
+		if f, ok := server.(reconfigurable); ok && strings.HasPrefix(key, service.ID+"-") {
+			forwarders = append(forwarders, f)
+		}
+	}
+	p.mu.Unlock()
+
+	if handler != nil {
+		next, err := p.newServiceHandler(service)
+		if err != nil {
+			p.takeBalancers(service.ID)
+			p.mu.Lock()
+			for key, lb := range previous {
+				p.balancers[key] = lb
+			}
+			p.mu.Unlock()
+			return err
+		}
+		handler.swap(next)
+	}
+	if len(forwarders) > 0 {
+		config := p.forwardConfigFor(service)
+		for _, f := range forwarders {
+			f.reconfigure(config)
+		}
+	}
+
+	p.registerHostname(service)
+	p.stopHealthCheck(service.ID)
+	p.startHealthCheck(ctx, service)
+
+	p.logger.Printf("Reloaded %s proxy service %s -> %s",
+		strings.ToUpper(service.Protocol), service.Name, p.balancerFor(service).describe())
+	return nil
+}
+
+// rebindService moves a running service to new listeners. On a new tunnel
+// port the new listeners are bound first and the old ones drain in the
+// background; otherwise the old ones must release the port before it can be
+// bound again.
+func (p *ProxyProvider) rebindService(ctx context.Context, service *models.ProxyService, previous serviceBinding) {
+	old := p.takeServers(service.ID)
+	if previous.port == service.TunnelPort {
+		p.shutdownServers(service.ID, old, 5*time.Second)
+		old = nil
+	}
+
+	// Draining listeners keep the handler they were started with
+	p.mu.Lock()
+	delete(p.handlers, service.ID)
+	p.mu.Unlock()
+	p.takeBalancers(service.ID)
+	p.stopHealthCheck(service.ID)
+
+	if err := p.startService(ctx, service); err != nil {
+		p.logger.Printf("Failed to rebind service %s: %v", service.ID, err)
+	}
+
+	if len(old) > 0 {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.shutdownServers(service.ID, old, p.shutdownTimeout)
+		}()
+	}
+}
+
+// takeServers removes the listeners of a service from the tracked servers
+// and returns them
+func (p *ProxyProvider) takeServers(id string) []tunnelServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var servers []tunnelServer
+	for key, server := range p.servers {
+		if strings.HasPrefix(key, id+"-") {
+			servers = append(servers, server)
+			delete(p.servers, key)
+		}
+	}
+	return servers
+}
+
+// shutdownServers stops listeners gracefully, letting open requests and
+// connections finish until the timeout before closing them
+func (p *ProxyProvider) shutdownServers(id string, servers []tunnelServer, timeout time.Duration) {
+	for _, server := range servers {
+		p.logger.Printf("Stopping server for service %s on %s", id, server.Address())
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			p.logger.Printf("Graceful shutdown failed for %s, forcing close: %v", server.Address(), err)
+			server.Close()
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// startNamedUpstream starts an HTTP upstream answering with name and returns
+// its port
+func startNamedUpstream(t *testing.T, name string) int {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// waitForPort waits until the loopback port accepts connections, or stops
+// accepting them when open is false. HTTP listeners bind in the background.
+func waitForPort(t *testing.T, port int, open bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) == open {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("port %d open = %v, want %v", port, !open, open)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSwappableHandler(t *testing.T) {
+	answer := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, body) })
+	}
+	h := newSwappableHandler(answer("first"))
+
+	for _, want := range []string{"first", "second", "third"} {
+		if want != "first" {
+			h.swap(answer(want))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Body.String() != want {
+			t.Fatalf("served %q, want %q", w.Body.String(), want)
+		}
+	}
+}
+
+func TestBindingOf(t *testing.T) {
+	base := models.ProxyService{Protocol: models.ProtocolHTTP, TunnelPort: 8000}
+
+	tests := []struct {
+		name   string
+		change func(*models.ProxyService)
+		rebind bool
+	}{
+		{name: "upstream", change: func(s *models.ProxyService) { s.LocalPort = 9000 }},
+		{name: "header rules", change: func(s *models.ProxyService) {
+			s.HeaderRules = []models.ProxyServiceHeaderRule{{Direction: models.HeaderRequest, Action: models.HeaderSet, Name: "X-A"}}
+		}},
+		{name: "websocket is served the same way", change: func(s *models.ProxyService) { s.Protocol = models.ProtocolWebSocket }},
+		{name: "tunnel port", change: func(s *models.ProxyService) { s.TunnelPort = 8001 }, rebind: true},
+		{name: "TLS", change: func(s *models.ProxyService) { s.TLSEnabled = true }, rebind: true},
+		{name: "protocol", change: func(s *models.ProxyService) { s.Protocol = models.ProtocolTCP }, rebind: true},
+		{name: "idle timeout", change: func(s *models.ProxyService) { s.IdleTimeout = 5 }, rebind: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			tt.change(&changed)
+			if rebind := bindingOf(&base) != bindingOf(&changed); rebind != tt.rebind {
+				t.Fatalf("rebind = %v, want %v", rebind, tt.rebind)
+			}
+		})
+	}
+}
+
+func TestReloadService(t *testing.T) {
+	p := newTestRepoProvider(t)
+	p.interfaces = map[string]string{"lo": "127.0.0.1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		p.wg.Wait()
+	}()
+	p.mu.Lock()
+	p.ctx = ctx
+	p.started = true
+	p.mu.Unlock()
+
+	tunnelPort := freeTCPPort(t)
+	service, err := p.repo.AddService("app", "127.0.0.1", startNamedUpstream(t, "v1"), tunnelPort, models.ProtocolHTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.startService(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	waitForPort(t, tunnelPort, true)
+
+	client := &http.Client{Transport: &http.Transport{}, Timeout: 5 * time.Second}
+	get := func(port int) (body string, reused bool) {
+		t.Helper()
+
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", port), nil)
+		resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data), reused
+	}
+	update := func(config models.ProxyServiceConfig) {
+		t.Helper()
+		if err := p.repo.UpdateService(service.ID, config); err != nil {
+			t.Fatal(err)
+		}
+		p.reloadService(service.ID)
+	}
+
+	if body, _ := get(tunnelPort); body != "v1" {
+		t.Fatalf("served %q, want v1", body)
+	}
+
+	// A new upstream is swapped in behind the open connection
+	update(models.ProxyServiceConfig{LocalPort: ptr(startNamedUpstream(t, "v2"))})
+	body, reused := get(tunnelPort)
+	if body != "v2" || !reused {
+		t.Fatalf("after swapping: served %q on a reused connection %v, want v2 on the same connection", body, reused)
+	}
+
+	// A new tunnel port binds new listeners and releases the old port
+	newPort := freeTCPPort(t)
+	update(models.ProxyServiceConfig{TunnelPort: ptr(newPort)})
+	waitForPort(t, newPort, true)
+	if body, _ := get(newPort); body != "v2" {
+		t.Fatalf("after rebinding: served %q, want v2", body)
+	}
+	client.CloseIdleConnections()
+	waitForPort(t, tunnelPort, false)
+
+	// Disabling stops the service
+	update(models.ProxyServiceConfig{Enabled: ptr(false)})
+	waitForPort(t, newPort, false)
+}
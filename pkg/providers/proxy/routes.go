@@ -1,11 +1,17 @@
 package proxy
 
 import (
+	"errors"
+	"fmt"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tphan267/arqut-edge-ce/pkg/api"
 	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
 )
 
 // ProxyServiceRequest represents the request body for creating a service
@@ -14,26 +20,236 @@ type ProxyServiceRequest struct {
 	Protocol  string `json:"protocol"`
 	LocalHost string `json:"local_host"`
 	LocalPort int    `json:"local_port"`
+
+	// TunnelPort requests a specific tunnel port instead of the first free
+	// one in the configured range
+	TunnelPort int `json:"tunnel_port"`
+
+	// Hostname serves the service as a virtual host on the shared listeners
+	Hostname string `json:"hostname"`
+
+	// Lifetime of a temporary service: either a TTL in seconds or an
+	// absolute expiry, and an optional use limit
+	TTL          int        `json:"ttl"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxUses      int        `json:"max_uses"`
+	ExpireAction string     `json:"expire_action"` // "disable" (default) or "delete"
 }
 
 // ProxyServiceUpdateRequest represents the request body for updating a service
 type ProxyServiceUpdateRequest struct {
-	Name      *string `json:"name"`
-	LocalHost *string `json:"local_host"`
-	LocalPort *int    `json:"local_port"`
-	Enabled   *bool   `json:"enabled"`
+	Name       *string `json:"name"`
+	LocalHost  *string `json:"local_host"`
+	LocalPort  *int    `json:"local_port"`
+	TunnelPort *int    `json:"tunnel_port"`
+	Enabled    *bool   `json:"enabled"`
+	Hostname   *string `json:"hostname"`
+
+	UpstreamTLS         *string `json:"upstream_tls"`
+	UpstreamCA          *string `json:"upstream_ca"`
+	UpstreamFingerprint *string `json:"upstream_fingerprint"`
+	UpstreamSNI         *string `json:"upstream_sni"`
+
+	TLSEnabled *bool `json:"tls_enabled"`
+
+	// Routes replaces every path route of the service when set
+	Routes *[]ProxyServiceRouteRequest `json:"routes"`
+
+	// Upstreams replaces every upstream target of the service when set
+	Upstreams     *[]ProxyServiceUpstreamRequest `json:"upstreams"`
+	LoadBalancing *string                        `json:"load_balancing"`
+
+	HealthCheck         *string `json:"health_check"`
+	HealthCheckPath     *string `json:"health_check_path"`
+	HealthCheckInterval *int    `json:"health_check_interval"`
+	HealthCheckTimeout  *int    `json:"health_check_timeout"`
+	HealthCheckStatus   *int    `json:"health_check_status"`
+	HealthyThreshold    *int    `json:"healthy_threshold"`
+	UnhealthyThreshold  *int    `json:"unhealthy_threshold"`
+
+	AuthMode     *string `json:"auth_mode"`
+	AuthUsername *string `json:"auth_username"`
+	AuthSecret   *string `json:"auth_secret"` // password or bearer token, stored hashed
+
+	// AccessRules replaces every access rule of the service when set
+	AccessRules *[]ProxyServiceAccessRuleRequest `json:"access_rules"`
+
+	// HeaderRules replaces every header rule of the service when set
+	HeaderRules *[]ProxyServiceHeaderRuleRequest `json:"header_rules"`
+
+	TimeoutProfile        *string `json:"timeout_profile"`
+	ReadHeaderTimeout     *int    `json:"read_header_timeout"`
+	IdleTimeout           *int    `json:"idle_timeout"`
+	WriteTimeout          *int    `json:"write_timeout"`
+	UpstreamHeaderTimeout *int    `json:"upstream_header_timeout"`
+	FlushInterval         *int    `json:"flush_interval"`
+
+	// Per-client limits, applied without restarting the listeners
+	RateLimit *int `json:"rate_limit"`
+	RateBurst *int `json:"rate_burst"`
+	ConnLimit *int `json:"conn_limit"`
+
+	BreakerThreshold *int `json:"breaker_threshold"`
+	BreakerCooldown  *int `json:"breaker_cooldown"`
+	RetryAttempts    *int `json:"retry_attempts"`
+
+	ShadowHost    *string `json:"shadow_host"`
+	ShadowPort    *int    `json:"shadow_port"`
+	ShadowPercent *int    `json:"shadow_percent"` // 0 disables mirroring
+
+	CaptureEnabled *bool `json:"capture_enabled"`
+	CaptureSize    *int  `json:"capture_size"`
+
+	Compression        *bool   `json:"compression"`
+	CompressionTypes   *string `json:"compression_types"`
+	CompressionMinSize *int    `json:"compression_min_size"`
+
+	CacheEnabled *bool `json:"cache_enabled"`
+	CacheSizeMB  *int  `json:"cache_size_mb"`
+
+	Maintenance           *bool `json:"maintenance"`
+	MaintenanceRetryAfter *int  `json:"maintenance_retry_after"`
+
+	// ErrorPages replaces every custom error page of the service when set
+	ErrorPages *[]ProxyServiceErrorPageRequest `json:"error_pages"`
+
+	// Schedules replaces every availability window of the service when set.
+	// An empty list removes the schedule.
+	Schedules        *[]ProxyServiceScheduleRequest `json:"schedules"`
+	ScheduleTimezone *string                        `json:"schedule_timezone"`
+
+	// TTL sets the expiry to that many seconds from now, 0 removes it
+	TTL          *int       `json:"ttl"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxUses      *int       `json:"max_uses"`
+	ExpireAction *string    `json:"expire_action"`
+}
+
+// ProxyServiceAccessRuleRequest represents one client IP rule in an update request
+type ProxyServiceAccessRuleRequest struct {
+	Action string `json:"action"` // "allow" or "deny"
+	CIDR   string `json:"cidr"`   // a CIDR or a single address
+}
+
+// ProxyServiceRouteRequest represents one path route in an update request
+type ProxyServiceRouteRequest struct {
+	PathPrefix  string `json:"path_prefix"`
+	LocalHost   string `json:"local_host"`
+	LocalPort   int    `json:"local_port"`
+	StripPrefix bool   `json:"strip_prefix"`
+}
+
+// ProxyServiceUpstreamRequest represents one upstream target in an update request
+type ProxyServiceUpstreamRequest struct {
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+}
+
+// ProxyServiceHeaderRuleRequest represents one header rewrite in an update request
+type ProxyServiceHeaderRuleRequest struct {
+	Direction string `json:"direction"` // "request" or "response"
+	Action    string `json:"action"`    // "add", "set" or "remove"
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+}
+
+// ProxyServiceErrorPageRequest represents one custom error page in an update request
+type ProxyServiceErrorPageRequest struct {
+	Status   int    `json:"status"`   // 502, 503 or 504
+	Template string `json:"template"` // HTML template
+}
+
+// ProxyServiceScheduleRequest represents one availability window in an update request
+type ProxyServiceScheduleRequest struct {
+	Days  string `json:"days"`  // "mon-fri", "sat,sun" or "*"
+	Start string `json:"start"` // HH:MM
+	End   string `json:"end"`   // HH:MM, before Start for windows past midnight
 }
 
 // ProxyServiceResponse represents the response for a proxy service
 type ProxyServiceResponse struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	TunnelPort int    `json:"tunnel_port"`
-	LocalHost  string `json:"local_host"`
-	LocalPort  int    `json:"local_port"`
-	Protocol   string `json:"protocol"`
-	Enabled    bool   `json:"enabled"`
-	CreatedAt  string `json:"created_at"`
+	ID         string             `json:"id"`
+	Name       string             `json:"name"`
+	TunnelPort int                `json:"tunnel_port"`
+	LocalHost  string             `json:"local_host"`
+	LocalPort  int                `json:"local_port"`
+	Protocol   string             `json:"protocol"`
+	Enabled    bool               `json:"enabled"`
+	Hostname   string             `json:"hostname,omitempty"`
+	CreatedAt  string             `json:"created_at"`
+	Stats      *ProxyServiceStats `json:"stats,omitempty"`
+
+	UpstreamTLS         string `json:"upstream_tls"`
+	UpstreamCA          string `json:"upstream_ca,omitempty"`
+	UpstreamFingerprint string `json:"upstream_fingerprint,omitempty"`
+	UpstreamSNI         string `json:"upstream_sni,omitempty"`
+
+	TLSEnabled bool `json:"tls_enabled"`
+
+	Routes []models.ProxyServiceRoute `json:"routes,omitempty"`
+
+	Upstreams     []models.ProxyServiceUpstream `json:"upstreams,omitempty"`
+	LoadBalancing string                        `json:"load_balancing,omitempty"`
+
+	HealthCheck         string              `json:"health_check"`
+	HealthCheckPath     string              `json:"health_check_path,omitempty"`
+	HealthCheckInterval int                 `json:"health_check_interval,omitempty"`
+	HealthCheckTimeout  int                 `json:"health_check_timeout,omitempty"`
+	HealthCheckStatus   int                 `json:"health_check_status,omitempty"`
+	HealthyThreshold    int                 `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold  int                 `json:"unhealthy_threshold,omitempty"`
+	Health              *ProxyServiceHealth `json:"health,omitempty"`
+
+	AuthMode     string `json:"auth_mode"`
+	AuthUsername string `json:"auth_username,omitempty"`
+
+	AccessRules []models.ProxyServiceAccessRule `json:"access_rules,omitempty"`
+
+	HeaderRules []models.ProxyServiceHeaderRule `json:"header_rules,omitempty"`
+
+	TimeoutProfile        string `json:"timeout_profile,omitempty"`
+	ReadHeaderTimeout     int    `json:"read_header_timeout,omitempty"`
+	IdleTimeout           int    `json:"idle_timeout,omitempty"`
+	WriteTimeout          int    `json:"write_timeout,omitempty"`
+	UpstreamHeaderTimeout int    `json:"upstream_header_timeout,omitempty"`
+	FlushInterval         int    `json:"flush_interval,omitempty"`
+
+	RateLimit int `json:"rate_limit"`
+	RateBurst int `json:"rate_burst"`
+	ConnLimit int `json:"conn_limit"`
+
+	BreakerThreshold int                  `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  int                  `json:"breaker_cooldown,omitempty"`
+	RetryAttempts    int                  `json:"retry_attempts,omitempty"`
+	Breaker          *ProxyServiceBreaker `json:"breaker,omitempty"`
+
+	ShadowHost    string `json:"shadow_host,omitempty"`
+	ShadowPort    int    `json:"shadow_port,omitempty"`
+	ShadowPercent int    `json:"shadow_percent,omitempty"`
+
+	CaptureEnabled bool `json:"capture_enabled"`
+	CaptureSize    int  `json:"capture_size,omitempty"`
+
+	Compression        bool   `json:"compression"`
+	CompressionTypes   string `json:"compression_types,omitempty"`
+	CompressionMinSize int    `json:"compression_min_size,omitempty"`
+
+	CacheEnabled bool `json:"cache_enabled"`
+	CacheSizeMB  int  `json:"cache_size_mb,omitempty"`
+
+	Maintenance           bool                           `json:"maintenance"`
+	MaintenanceRetryAfter int                            `json:"maintenance_retry_after,omitempty"`
+	ErrorPages            []models.ProxyServiceErrorPage `json:"error_pages,omitempty"`
+
+	Schedules          []models.ProxyServiceSchedule `json:"schedules,omitempty"`
+	ScheduleTimezone   string                        `json:"schedule_timezone,omitempty"`
+	NextScheduleChange *time.Time                    `json:"next_schedule_change,omitempty"`
+
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxUses      int        `json:"max_uses,omitempty"`
+	Uses         int        `json:"uses"`
+	ExpireAction string     `json:"expire_action,omitempty"`
 }
 
 // RegisterRoutes registers all proxy-related API routes
@@ -46,6 +262,13 @@ func (p *ProxyProvider) RegisterRoutes(router fiber.Router, middlewares ...fiber
 	proxyAPI.Patch("/:id/enable", p.handleEnableService)
 	proxyAPI.Patch("/:id/disable", p.handleDisableService)
 	proxyAPI.Delete("/:id", p.handleDeleteService)
+	proxyAPI.Get("/:id/access-log", p.handleGetAccessLog)
+	proxyAPI.Get("/:id/captures", p.handleGetCaptures)
+	proxyAPI.Post("/:id/captures/:cid/replay", p.handleReplayCapture)
+	proxyAPI.Delete("/:id/cache", p.handlePurgeCache)
+
+	tlsAPI := router.Group("/tls", middlewares...)
+	tlsAPI.Get("/ca.crt", p.handleGetCACertificate)
 }
 
 // handleGetServices handles GET /api/services - returns all proxy services
@@ -66,7 +289,80 @@ func (p *ProxyProvider) handleGetServices(c *fiber.Ctx) error {
 			LocalPort:  service.LocalPort,
 			Protocol:   service.Protocol,
 			Enabled:    service.Enabled,
+			Hostname:   service.Hostname,
 			CreatedAt:  service.CreatedAt.Format("2006-01-02 15:04:05"),
+			Stats:      p.GetServiceStats(service.ID),
+
+			UpstreamTLS:         service.UpstreamTLS,
+			UpstreamCA:          service.UpstreamCA,
+			UpstreamFingerprint: service.UpstreamFingerprint,
+			UpstreamSNI:         service.UpstreamSNI,
+
+			TLSEnabled: service.TLSEnabled,
+
+			Routes: service.Routes,
+
+			Upstreams:     service.Upstreams,
+			LoadBalancing: service.LoadBalancing,
+
+			HealthCheck:         service.HealthCheck,
+			HealthCheckPath:     service.HealthCheckPath,
+			HealthCheckInterval: service.HealthCheckInterval,
+			HealthCheckTimeout:  service.HealthCheckTimeout,
+			HealthCheckStatus:   service.HealthCheckStatus,
+			HealthyThreshold:    service.HealthyThreshold,
+			UnhealthyThreshold:  service.UnhealthyThreshold,
+			Health:              p.GetServiceHealth(service.ID),
+
+			AuthMode:     service.AuthMode,
+			AuthUsername: service.AuthUsername,
+
+			AccessRules: service.AccessRules,
+
+			HeaderRules: service.HeaderRules,
+
+			TimeoutProfile:        service.TimeoutProfile,
+			ReadHeaderTimeout:     service.ReadHeaderTimeout,
+			IdleTimeout:           service.IdleTimeout,
+			WriteTimeout:          service.WriteTimeout,
+			UpstreamHeaderTimeout: service.UpstreamHeaderTimeout,
+			FlushInterval:         service.FlushInterval,
+
+			RateLimit: service.RateLimit,
+			RateBurst: service.RateBurst,
+			ConnLimit: service.ConnLimit,
+
+			BreakerThreshold: service.BreakerThreshold,
+			BreakerCooldown:  service.BreakerCooldown,
+			RetryAttempts:    service.RetryAttempts,
+			Breaker:          p.GetServiceBreaker(service.ID),
+
+			ShadowHost:    service.ShadowHost,
+			ShadowPort:    service.ShadowPort,
+			ShadowPercent: service.ShadowPercent,
+
+			CaptureEnabled: service.CaptureEnabled,
+			CaptureSize:    service.CaptureSize,
+
+			Compression:        service.Compression,
+			CompressionTypes:   service.CompressionTypes,
+			CompressionMinSize: service.CompressionMinSize,
+
+			CacheEnabled: service.CacheEnabled,
+			CacheSizeMB:  service.CacheSizeMB,
+
+			Maintenance:           service.Maintenance,
+			MaintenanceRetryAfter: service.MaintenanceRetryAfter,
+			ErrorPages:            service.ErrorPages,
+
+			Schedules:          service.Schedules,
+			ScheduleTimezone:   service.ScheduleTimezone,
+			NextScheduleChange: p.GetNextScheduleChange(service),
+
+			ExpiresAt:    service.ExpiresAt,
+			MaxUses:      service.MaxUses,
+			Uses:         p.serviceUses(service),
+			ExpireAction: service.ExpireAction,
 		})
 	}
 
@@ -89,9 +385,31 @@ func (p *ProxyProvider) handleCreateService(c *fiber.Ctx) error {
 		return api.ErrorBadRequestResp(c, "Missing required fields (name, local_host)")
 	}
 
-	service, err := p.AddService(req.Name, req.LocalHost, req.LocalPort, req.Protocol)
+	var options []models.ProxyServiceConfig
+	if req.TunnelPort != 0 {
+		options = append(options, models.ProxyServiceConfig{TunnelPort: &req.TunnelPort})
+	}
+	if req.Hostname != "" {
+		options = append(options, models.ProxyServiceConfig{Hostname: &req.Hostname})
+	}
+	if req.TTL != 0 || req.ExpiresAt != nil || req.MaxUses != 0 || req.ExpireAction != "" {
+		expiresAt, err := lifetimeExpiry(&req.TTL, req.ExpiresAt)
+		if err != nil {
+			return api.ErrorBadRequestResp(c, err.Error())
+		}
+		options = append(options, models.ProxyServiceConfig{
+			ExpiresAt:    expiresAt,
+			MaxUses:      &req.MaxUses,
+			ExpireAction: &req.ExpireAction,
+		})
+	}
+
+	service, err := p.AddService(req.Name, req.LocalHost, req.LocalPort, req.Protocol, options...)
 	if err != nil {
 		p.logger.Printf("Error creating service: %v", err)
+		if msg, ok := validationMessage(err); ok {
+			return api.ErrorBadRequestResp(c, msg)
+		}
 		return api.ErrorInternalServerErrorResp(c, "Failed to create service")
 	}
 
@@ -119,14 +437,161 @@ func (p *ProxyProvider) handleUpdateService(c *fiber.Ctx) error {
 	}
 
 	config := models.ProxyServiceConfig{
-		Name:      req.Name,
-		LocalHost: req.LocalHost,
-		LocalPort: req.LocalPort,
-		Enabled:   req.Enabled,
+		Name:       req.Name,
+		LocalHost:  req.LocalHost,
+		LocalPort:  req.LocalPort,
+		TunnelPort: req.TunnelPort,
+		Enabled:    req.Enabled,
+		Hostname:   req.Hostname,
+
+		UpstreamTLS:         req.UpstreamTLS,
+		UpstreamCA:          req.UpstreamCA,
+		UpstreamFingerprint: req.UpstreamFingerprint,
+		UpstreamSNI:         req.UpstreamSNI,
+
+		TLSEnabled: req.TLSEnabled,
+
+		LoadBalancing: req.LoadBalancing,
+
+		HealthCheck:         req.HealthCheck,
+		HealthCheckPath:     req.HealthCheckPath,
+		HealthCheckInterval: req.HealthCheckInterval,
+		HealthCheckTimeout:  req.HealthCheckTimeout,
+		HealthCheckStatus:   req.HealthCheckStatus,
+		HealthyThreshold:    req.HealthyThreshold,
+		UnhealthyThreshold:  req.UnhealthyThreshold,
+
+		AuthMode:     req.AuthMode,
+		AuthUsername: req.AuthUsername,
+		AuthSecret:   req.AuthSecret,
+
+		TimeoutProfile:        req.TimeoutProfile,
+		ReadHeaderTimeout:     req.ReadHeaderTimeout,
+		IdleTimeout:           req.IdleTimeout,
+		WriteTimeout:          req.WriteTimeout,
+		UpstreamHeaderTimeout: req.UpstreamHeaderTimeout,
+		FlushInterval:         req.FlushInterval,
+
+		RateLimit: req.RateLimit,
+		RateBurst: req.RateBurst,
+		ConnLimit: req.ConnLimit,
+
+		BreakerThreshold: req.BreakerThreshold,
+		BreakerCooldown:  req.BreakerCooldown,
+		RetryAttempts:    req.RetryAttempts,
+
+		ShadowHost:    req.ShadowHost,
+		ShadowPort:    req.ShadowPort,
+		ShadowPercent: req.ShadowPercent,
+
+		CaptureEnabled: req.CaptureEnabled,
+		CaptureSize:    req.CaptureSize,
+
+		Compression:        req.Compression,
+		CompressionTypes:   req.CompressionTypes,
+		CompressionMinSize: req.CompressionMinSize,
+
+		CacheEnabled: req.CacheEnabled,
+		CacheSizeMB:  req.CacheSizeMB,
+
+		Maintenance:           req.Maintenance,
+		MaintenanceRetryAfter: req.MaintenanceRetryAfter,
+
+		ScheduleTimezone: req.ScheduleTimezone,
+
+		MaxUses:      req.MaxUses,
+		ExpireAction: req.ExpireAction,
+	}
+
+	if req.TTL != nil || req.ExpiresAt != nil {
+		expiresAt, err := lifetimeExpiry(req.TTL, req.ExpiresAt)
+		if err != nil {
+			return api.ErrorBadRequestResp(c, err.Error())
+		}
+		if expiresAt == nil {
+			// A zero TTL removes the expiry
+			expiresAt = &time.Time{}
+		}
+		config.ExpiresAt = expiresAt
+	}
+
+	if req.Routes != nil {
+		routes := make([]models.ProxyServiceRoute, 0, len(*req.Routes))
+		for _, route := range *req.Routes {
+			routes = append(routes, models.ProxyServiceRoute{
+				PathPrefix:  route.PathPrefix,
+				LocalHost:   route.LocalHost,
+				LocalPort:   route.LocalPort,
+				StripPrefix: route.StripPrefix,
+			})
+		}
+		config.Routes = &routes
+	}
+
+	if req.Upstreams != nil {
+		upstreams := make([]models.ProxyServiceUpstream, 0, len(*req.Upstreams))
+		for _, upstream := range *req.Upstreams {
+			upstreams = append(upstreams, models.ProxyServiceUpstream{
+				Host:   upstream.Host,
+				Port:   upstream.Port,
+				Weight: upstream.Weight,
+			})
+		}
+		config.Upstreams = &upstreams
+	}
+
+	if req.AccessRules != nil {
+		rules := make([]models.ProxyServiceAccessRule, 0, len(*req.AccessRules))
+		for _, rule := range *req.AccessRules {
+			rules = append(rules, models.ProxyServiceAccessRule{
+				Action: rule.Action,
+				CIDR:   rule.CIDR,
+			})
+		}
+		config.AccessRules = &rules
+	}
+
+	if req.HeaderRules != nil {
+		rules := make([]models.ProxyServiceHeaderRule, 0, len(*req.HeaderRules))
+		for _, rule := range *req.HeaderRules {
+			rules = append(rules, models.ProxyServiceHeaderRule{
+				Direction: rule.Direction,
+				Action:    rule.Action,
+				Name:      rule.Name,
+				Value:     rule.Value,
+			})
+		}
+		config.HeaderRules = &rules
+	}
+
+	if req.ErrorPages != nil {
+		pages := make([]models.ProxyServiceErrorPage, 0, len(*req.ErrorPages))
+		for _, page := range *req.ErrorPages {
+			pages = append(pages, models.ProxyServiceErrorPage{
+				Status:   page.Status,
+				Template: page.Template,
+			})
+		}
+		config.ErrorPages = &pages
+	}
+
+	if req.Schedules != nil {
+		schedules := make([]models.ProxyServiceSchedule, 0, len(*req.Schedules))
+		for _, schedule := range *req.Schedules {
+			schedules = append(schedules, models.ProxyServiceSchedule{
+				Days:  schedule.Days,
+				Start: schedule.Start,
+				End:   schedule.End,
+			})
+		}
+		config.Schedules = &schedules
 	}
 
 	if err := p.ModifyService(serviceID, config); err != nil {
 		p.logger.Printf("Error updating service: %v", err)
+		if msg, ok := validationMessage(err); ok {
+			return api.ErrorBadRequestResp(c, msg)
+		}
 		return api.ErrorInternalServerErrorResp(c, "Failed to update service")
 	}
 
@@ -163,6 +628,17 @@ func (p *ProxyProvider) handleDisableService(c *fiber.Ctx) error {
 	return api.SuccessResp(c, nil)
 }
 
+// handleGetCACertificate handles GET /api/tls/ca.crt - downloads the edge CA certificate
+func (p *ProxyProvider) handleGetCACertificate(c *fiber.Ctx) error {
+	if p.ca == nil {
+		return api.ErrorCodeResp(c, fiber.StatusServiceUnavailable, "Certificate authority not available")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-x509-ca-cert")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="arqut-edge-ca.crt"`)
+	return c.SendString(p.ca.CertificatePEM())
+}
+
 // handleDeleteService handles DELETE /api/services/:id - deletes a proxy service
 func (p *ProxyProvider) handleDeleteService(c *fiber.Ctx) error {
 	serviceID := c.Params("id")
@@ -177,3 +653,174 @@ func (p *ProxyProvider) handleDeleteService(c *fiber.Ctx) error {
 
 	return api.SuccessResp(c, nil)
 }
+
+// handleGetCaptures handles GET /api/services/:id/captures - lists the
+// requests kept by the service's inspector, newest first
+func (p *ProxyProvider) handleGetCaptures(c *fiber.Ctx) error {
+	serviceID := c.Params("id")
+	if serviceID == "" {
+		return api.ErrorBadRequestResp(c, "Service ID is required")
+	}
+
+	return api.SuccessResp(c, p.GetCaptures(serviceID))
+}
+
+// handleReplayCapture handles POST /api/services/:id/captures/:cid/replay -
+// resends a captured request to the service's upstream
+func (p *ProxyProvider) handleReplayCapture(c *fiber.Ctx) error {
+	serviceID := c.Params("id")
+	captureID := c.Params("cid")
+	if serviceID == "" || captureID == "" {
+		return api.ErrorBadRequestResp(c, "Service ID and capture ID are required")
+	}
+
+	capture, err := p.ReplayCapture(serviceID, captureID)
+	if err != nil {
+		return api.ErrorBadRequestResp(c, err.Error())
+	}
+	if capture == nil {
+		return api.ErrorNotFoundResp(c, "Capture not found")
+	}
+
+	return api.SuccessResp(c, capture)
+}
+
+// handlePurgeCache handles DELETE /api/services/:id/cache - removes every
+// cached response of a service
+func (p *ProxyProvider) handlePurgeCache(c *fiber.Ctx) error {
+	serviceID := c.Params("id")
+	if serviceID == "" {
+		return api.ErrorBadRequestResp(c, "Service ID is required")
+	}
+
+	entries, size := p.PurgeCache(serviceID)
+	p.logger.Printf("Purged %d cached responses (%d bytes) of service %s", entries, size, serviceID)
+
+	return api.SuccessResp(c, fiber.Map{
+		"entries": entries,
+		"bytes":   size,
+	})
+}
+
+const (
+	defaultAccessLogLimit = 100
+	maxAccessLogLimit     = 1000
+)
+
+// handleGetAccessLog handles GET /api/services/:id/access-log - queries the
+// access log of a service, newest first. Supports from and to (RFC 3339 or
+// unix seconds), status (404, 5xx or 400-499), path (prefix) and limit.
+func (p *ProxyProvider) handleGetAccessLog(c *fiber.Ctx) error {
+	serviceID := c.Params("id")
+	if serviceID == "" {
+		return api.ErrorBadRequestResp(c, "Service ID is required")
+	}
+	if p.accessLog == nil {
+		return api.ErrorCodeResp(c, fiber.StatusServiceUnavailable, "Access log not available")
+	}
+
+	filter := models.AccessLogFilter{
+		ServiceID:  serviceID,
+		PathPrefix: c.Query("path"),
+		Limit:      c.QueryInt("limit", defaultAccessLogLimit),
+	}
+	if filter.Limit <= 0 || filter.Limit > maxAccessLogLimit {
+		return api.ErrorBadRequestResp(c, "limit must be between 1 and "+strconv.Itoa(maxAccessLogLimit))
+	}
+
+	var err error
+	if filter.From, err = parseQueryTime(c.Query("from")); err != nil {
+		return api.ErrorBadRequestResp(c, "Invalid from time")
+	}
+	if filter.To, err = parseQueryTime(c.Query("to")); err != nil {
+		return api.ErrorBadRequestResp(c, "Invalid to time")
+	}
+	if filter.StatusMin, filter.StatusMax, err = parseStatusFilter(c.Query("status")); err != nil {
+		return api.ErrorBadRequestResp(c, "Invalid status filter")
+	}
+
+	entries, err := p.accessLog.repo.GetEntries(filter)
+	if err != nil {
+		p.logger.Printf("Error querying access log: %v", err)
+		return api.ErrorInternalServerErrorResp(c, "Failed to query access log")
+	}
+
+	return api.SuccessResp(c, entries)
+}
+
+// parseQueryTime parses an RFC 3339 or unix seconds time; empty is zero
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseStatusFilter parses a status code (404), class (5xx) or range
+// (400-499) into inclusive bounds; empty matches any status
+func parseStatusFilter(value string) (int, int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, 0, nil
+	}
+
+	if class, ok := strings.CutSuffix(value, "xx"); ok {
+		n, err := strconv.Atoi(class)
+		if err != nil || n < 1 || n > 5 {
+			return 0, 0, fiber.ErrBadRequest
+		}
+		return n * 100, n*100 + 99, nil
+	}
+
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+	min, err := strconv.Atoi(low)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := strconv.Atoi(high)
+	if err != nil {
+		return 0, 0, err
+	}
+	if min < 100 || max > 599 || min > max {
+		return 0, 0, fiber.ErrBadRequest
+	}
+	return min, max, nil
+}
+
+// lifetimeExpiry resolves the expiry of a temporary service from a TTL in
+// seconds or an absolute time, returning nil for no expiry
+func lifetimeExpiry(ttl *int, expiresAt *time.Time) (*time.Time, error) {
+	hasTTL := ttl != nil && *ttl != 0
+	switch {
+	case hasTTL && expiresAt != nil:
+		return nil, fmt.Errorf("set either ttl or expires_at, not both")
+	case hasTTL:
+		if *ttl < 0 {
+			return nil, fmt.Errorf("ttl cannot be negative")
+		}
+		expiry := time.Now().Add(time.Duration(*ttl) * time.Second)
+		return &expiry, nil
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("expires_at must be in the future")
+		}
+		return expiresAt, nil
+	}
+	return nil, nil
+}
+
+// validationMessage returns the message of an error caused by an invalid
+// service configuration, reporting false for storage and other failures
+func validationMessage(err error) (string, bool) {
+	var invalid *repositories.ValidationError
+	if !errors.As(err, &invalid) {
+		return "", false
+	}
+	return invalid.Error(), true
+}
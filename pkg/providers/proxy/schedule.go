@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/utils"
+)
+
+// scheduleLookahead bounds the search for a service's next scheduled change
+const scheduleLookahead = 8 * 24 * time.Hour
+
+// scheduleWindow is a parsed availability window
+type scheduleWindow struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes after midnight
+}
+
+// serviceSchedule is the parsed set of availability windows of a service
+type serviceSchedule struct {
+	windows  []scheduleWindow
+	location *time.Location
+}
+
+// newServiceSchedule parses the availability windows of a service, returning
+// nil if it has none
+func newServiceSchedule(service *models.ProxyService) (*serviceSchedule, error) {
+	if len(service.Schedules) == 0 {
+		return nil, nil
+	}
+
+	location, err := utils.LoadTimezone(service.ScheduleTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule timezone %q: %w", service.ScheduleTimezone, err)
+	}
+
+	s := &serviceSchedule{location: location}
+	for _, schedule := range service.Schedules {
+		var w scheduleWindow
+		if w.days, err = utils.ParseWeekdays(schedule.Days); err != nil {
+			return nil, err
+		}
+		if w.start, err = utils.ParseClock(schedule.Start); err != nil {
+			return nil, err
+		}
+		if w.end, err = utils.ParseClock(schedule.End); err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// active reports whether t falls inside any window
+func (s *serviceSchedule) active(t time.Time) bool {
+	t = t.In(s.location)
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[day] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the window belongs to the day it starts on
+		if w.days[day] && minute >= w.start {
+			return true
+		}
+		if w.days[(day+6)%7] && minute < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// nextChange returns the next minute at which the schedule turns the service
+// on or off, or nil if it never does. The service can only change state at a
+// window boundary or where the timezone's offset changes, so the search walks
+// the spans of fixed offset up to the lookahead and checks those instants.
+func (s *serviceSchedule) nextChange(now time.Time) *time.Time {
+	current := s.active(now)
+	limit := now.Add(scheduleLookahead)
+
+	for start := now; start.Before(limit); {
+		local := start.In(s.location)
+		_, offset := local.Zone()
+		_, end := local.ZoneBounds()
+		if end.IsZero() || end.After(limit) {
+			end = limit
+		}
+
+		candidates := s.boundaries(start, end, offset)
+		if start.After(now) {
+			candidates = append(candidates, start)
+		}
+		slices.SortFunc(candidates, time.Time.Compare)
+		for _, t := range candidates {
+			if t.After(now) && s.active(t) != current {
+				t = t.In(now.Location())
+				return &t
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// boundaries returns the instants in [start, end) at which a window starts or
+// ends, given the timezone's offset in seconds throughout that span
+func (s *serviceSchedule) boundaries(start, end time.Time, offset int) []time.Time {
+	shift := time.Duration(offset) * time.Second
+	first := start.UTC().Add(shift).Truncate(24*time.Hour).AddDate(0, 0, -1)
+	last := end.UTC().Add(shift)
+
+	var instants []time.Time
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		for _, w := range s.windows {
+			for _, minute := range []int{w.start, w.end} {
+				t := day.Add(time.Duration(minute)*time.Minute - shift)
+				if !t.Before(start) && t.Before(end) {
+					instants = append(instants, t)
+				}
+			}
+		}
+	}
+	return instants
+}
+
+// runScheduler enables and disables scheduled services at the boundaries of
+// their windows, and expires temporary services, until ctx is cancelled
+func (p *ProxyProvider) runScheduler(ctx context.Context) {
+	for {
+		p.applySchedules(time.Now())
+		p.expireServices(time.Now())
+
+		// Wake just after the start of the next minute
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute + time.Second).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// applySchedules brings every scheduled service in line with its windows.
+// A service is only switched when a boundary has been crossed since the last
+// run, so enabling or disabling it by hand holds until the next boundary.
+// The first run after startup or a schedule change applies the current state.
+// Expired services are skipped.
+func (p *ProxyProvider) applySchedules(now time.Time) {
+	services, err := p.repo.GetServices()
+	if err != nil {
+		p.logger.Printf("[Proxy] Failed to load services for scheduling: %v", err)
+		return
+	}
+
+	for _, service := range services {
+		if p.serviceExpired(service, now) {
+			// Left to expireServices; enabling it would only undo the expiry
+			continue
+		}
+
+		schedule, err := newServiceSchedule(service)
+		if err != nil {
+			p.logger.Printf("[Proxy] %s -> invalid schedule: %v", service.Name, err)
+			continue
+		}
+
+		p.mu.Lock()
+		if schedule == nil {
+			delete(p.scheduleStates, service.ID)
+			p.mu.Unlock()
+			continue
+		}
+		want := schedule.active(now)
+		last, seen := p.scheduleStates[service.ID]
+		p.scheduleStates[service.ID] = want
+		p.mu.Unlock()
+
+		if (seen && last == want) || service.Enabled == want {
+			continue
+		}
+
+		action := "disabled"
+		if want {
+			action = "enabled"
+			err = p.EnableService(service.ID)
+		} else {
+			err = p.DisableService(service.ID)
+		}
+		if err != nil {
+			p.logger.Printf("[Proxy] %s -> scheduled change failed: %v", service.Name, err)
+			continue
+		}
+		p.logger.Printf("[Proxy] %s -> %s by schedule", service.Name, action)
+	}
+}
+
+// resetSchedule makes the next scheduler run apply a service's schedule
+// as it stands, after its windows have changed
+func (p *ProxyProvider) resetSchedule(id string) {
+	p.mu.Lock()
+	delete(p.scheduleStates, id)
+	p.mu.Unlock()
+}
+
+// GetNextScheduleChange returns when a scheduled service is next enabled or
+// disabled by its schedule, or nil if it has no schedule
+func (p *ProxyProvider) GetNextScheduleChange(service *models.ProxyService) *time.Time {
+	schedule, err := newServiceSchedule(service)
+	if err != nil || schedule == nil {
+		return nil
+	}
+	return schedule.nextChange(time.Now())
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// newTestSchedule parses windows given as days, start and end triples in
+// the America/New_York timezone
+func newTestSchedule(t *testing.T, windows ...[3]string) *serviceSchedule {
+	t.Helper()
+
+	service := &models.ProxyService{ScheduleTimezone: "America/New_York"}
+	for _, w := range windows {
+		service.Schedules = append(service.Schedules, models.ProxyServiceSchedule{Days: w[0], Start: w[1], End: w[2]})
+	}
+	s, err := newServiceSchedule(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newYork returns a wall clock time in America/New_York
+func newYork(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestScheduleActive(t *testing.T) {
+	// 2026-10-16 is a Friday
+	tests := []struct {
+		name    string
+		windows [][3]string
+		at      string
+		want    bool
+	}{
+		{name: "inside window", windows: [][3]string{{"mon-fri", "09:00", "17:00"}}, at: "2026-10-16 09:00", want: true},
+		{name: "end is exclusive", windows: [][3]string{{"mon-fri", "09:00", "17:00"}}, at: "2026-10-16 17:00", want: false},
+		{name: "other day", windows: [][3]string{{"mon-fri", "09:00", "17:00"}}, at: "2026-10-17 10:00", want: false},
+		{name: "until end of day", windows: [][3]string{{"*", "20:00", "24:00"}}, at: "2026-10-16 23:59", want: true},
+		{name: "overnight before midnight", windows: [][3]string{{"fri", "22:00", "02:00"}}, at: "2026-10-16 23:00", want: true},
+		{name: "overnight after midnight", windows: [][3]string{{"fri", "22:00", "02:00"}}, at: "2026-10-17 01:59", want: true},
+		{name: "overnight belongs to its start day", windows: [][3]string{{"fri", "22:00", "02:00"}}, at: "2026-10-17 22:30", want: false},
+		{name: "overnight ends", windows: [][3]string{{"fri", "22:00", "02:00"}}, at: "2026-10-17 02:00", want: false},
+		{name: "overnight wraps the week", windows: [][3]string{{"sat", "23:00", "01:00"}}, at: "2026-10-18 00:30", want: true},
+		{name: "any window", windows: [][3]string{{"mon", "08:00", "09:00"}, {"fri", "12:00", "13:00"}}, at: "2026-10-16 12:30", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSchedule(t, tt.windows...)
+			if got := s.active(newYork(t, tt.at)); got != tt.want {
+				t.Fatalf("active(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows [][3]string
+		now     time.Time
+		want    time.Time // zero for no change
+	}{
+		{
+			name:    "end of window",
+			windows: [][3]string{{"mon-fri", "09:00", "17:00"}},
+			now:     newYork(t, "2026-10-16 16:00"),
+			want:    newYork(t, "2026-10-16 17:00"),
+		},
+		{
+			name:    "over the weekend",
+			windows: [][3]string{{"mon-fri", "09:00", "17:00"}},
+			now:     newYork(t, "2026-10-16 17:00"),
+			want:    newYork(t, "2026-10-19 09:00"),
+		},
+		{
+			name:    "across midnight",
+			windows: [][3]string{{"fri", "22:00", "02:00"}},
+			now:     newYork(t, "2026-10-16 23:00"),
+			want:    newYork(t, "2026-10-17 02:00"),
+		},
+		{
+			name:    "mid minute",
+			windows: [][3]string{{"*", "09:00", "17:00"}},
+			now:     newYork(t, "2026-10-16 08:59").Add(30 * time.Second),
+			want:    newYork(t, "2026-10-16 09:00"),
+		},
+		{
+			name:    "adjacent windows are one",
+			windows: [][3]string{{"*", "09:00", "12:00"}, {"*", "12:00", "17:00"}},
+			now:     newYork(t, "2026-10-16 10:00"),
+			want:    newYork(t, "2026-10-16 17:00"),
+		},
+		{
+			// 02:30 does not exist on 2026-03-08; the clock jumps from 02:00 EST to 03:00 EDT
+			name:    "start skipped by DST",
+			windows: [][3]string{{"*", "02:30", "04:00"}},
+			now:     newYork(t, "2026-03-08 01:00"),
+			want:    time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "day shortened by DST",
+			windows: [][3]string{{"*", "00:00", "12:00"}},
+			now:     newYork(t, "2026-03-08 13:00"),
+			want:    newYork(t, "2026-03-09 00:00"),
+		},
+		{
+			// 01:30 happens twice on 2026-11-01, first in EDT then in EST
+			name:    "repeated hour ends",
+			windows: [][3]string{{"*", "01:30", "01:45"}},
+			now:     time.Date(2026, 11, 1, 5, 40, 0, 0, time.UTC),
+			want:    time.Date(2026, 11, 1, 5, 45, 0, 0, time.UTC),
+		},
+		{
+			name:    "repeated hour starts again",
+			windows: [][3]string{{"*", "01:30", "01:45"}},
+			now:     time.Date(2026, 11, 1, 5, 50, 0, 0, time.UTC),
+			want:    time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:    "always on",
+			windows: [][3]string{{"*", "00:00", "24:00"}},
+			now:     newYork(t, "2026-10-16 12:00"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSchedule(t, tt.windows...)
+			got := s.nextChange(tt.now)
+			switch {
+			case tt.want.IsZero() && got != nil:
+				t.Fatalf("nextChange = %s, want none", got)
+			case !tt.want.IsZero() && (got == nil || !got.Equal(tt.want)):
+				t.Fatalf("nextChange = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextChangeMatchesEveryMinute(t *testing.T) {
+	s := newTestSchedule(t, [3]string{"mon,wed,sat", "01:15", "02:45"}, [3]string{"fri-sun", "23:00", "03:00"})
+
+	// Step through both DST changes of 2026 and compare against a scan of
+	// every minute
+	for _, start := range []string{"2026-03-06 00:00", "2026-10-30 00:00"} {
+		for now := newYork(t, start); now.Before(newYork(t, start).Add(72 * time.Hour)); now = now.Add(37 * time.Minute) {
+			want := now.Truncate(time.Minute).Add(time.Minute)
+			for s.active(want) == s.active(now) {
+				want = want.Add(time.Minute)
+			}
+			if got := s.nextChange(now); got == nil || !got.Equal(want) {
+				t.Fatalf("nextChange(%s) = %v, want %s", now, got, want)
+			}
+		}
+	}
+}
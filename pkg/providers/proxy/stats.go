@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"sync/atomic"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
+)
+
+// serviceStats holds runtime traffic counters for a service, shared by all of
+// its listeners across interfaces
+type serviceStats struct {
+	activeSessions atomic.Int64
+	totalSessions  atomic.Int64
+	bytesIn        atomic.Int64
+	bytesOut       atomic.Int64
+	denied         atomic.Int64
+	limited        atomic.Int64
+	upstreamErrors atomic.Int64
+
+	retries         atomic.Int64 // upstream requests sent again after a failure
+	breakerTrips    atomic.Int64
+	breakerRejected atomic.Int64
+
+	mirrored      atomic.Int64 // requests copied to the shadow upstream
+	mirrorErrors  atomic.Int64
+	mirrorDropped atomic.Int64 // sampled requests not copied
+
+	requests [6]atomic.Int64    // HTTP requests by status class, 1xx at index 1
+	latency  *metrics.Histogram // HTTP request latency in seconds
+}
+
+// newServiceStats creates zeroed counters
+func newServiceStats() *serviceStats {
+	return &serviceStats{latency: metrics.NewHistogram(metrics.DefaultLatencyBuckets)}
+}
+
+// countRequest records a completed HTTP request
+func (s *serviceStats) countRequest(status int, seconds float64) {
+	class := status / 100
+	if class < 1 || class > 5 {
+		class = 5
+	}
+	s.requests[class].Add(1)
+	s.latency.Observe(seconds)
+}
+
+// totalRequests returns the number of HTTP requests of all status classes
+func (s *serviceStats) totalRequests() int64 {
+	var total int64
+	for i := range s.requests {
+		total += s.requests[i].Load()
+	}
+	return total
+}
+
+// ProxyServiceStats is a point-in-time snapshot of a service's traffic counters
+type ProxyServiceStats struct {
+	ActiveSessions int64 `json:"active_sessions"`
+	TotalSessions  int64 `json:"total_sessions"`
+	BytesIn        int64 `json:"bytes_in"`
+	BytesOut       int64 `json:"bytes_out"`
+	Denied         int64 `json:"denied"`
+	Limited        int64 `json:"limited"`
+	Requests       int64 `json:"requests"`
+	UpstreamErrors int64 `json:"upstream_errors"`
+	Retries        int64 `json:"retries"`
+	Mirrored       int64 `json:"mirrored"`
+}
+
+// snapshot returns the current counter values
+func (s *serviceStats) snapshot() *ProxyServiceStats {
+	return &ProxyServiceStats{
+		ActiveSessions: s.activeSessions.Load(),
+		TotalSessions:  s.totalSessions.Load(),
+		BytesIn:        s.bytesIn.Load(),
+		BytesOut:       s.bytesOut.Load(),
+		Denied:         s.denied.Load(),
+		Limited:        s.limited.Load(),
+		Requests:       s.totalRequests(),
+		UpstreamErrors: s.upstreamErrors.Load(),
+		Retries:        s.retries.Load(),
+		Mirrored:       s.mirrored.Load(),
+	}
+}
+
+// statsFor returns the counters for a service, creating them on first use
+func (p *ProxyProvider) statsFor(id string) *serviceStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats, exists := p.stats[id]
+	if !exists {
+		stats = newServiceStats()
+		p.stats[id] = stats
+	}
+	return stats
+}
+
+// GetServiceStats returns a snapshot of a service's traffic counters, or nil
+// if the service has not carried any traffic since startup
+func (p *ProxyProvider) GetServiceStats(id string) *ProxyServiceStats {
+	p.mu.RLock()
+	stats, exists := p.stats[id]
+	p.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return stats.snapshot()
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// tcpDialTimeout bounds how long a new tunnel connection waits for the upstream
+const tcpDialTimeout = 10 * time.Second
+
+// tcpForwarder forwards raw TCP connections from a tunnel address to a LAN target
+type tcpForwarder struct {
+	addr     string
+	config   atomic.Pointer[forwardConfig]
+	stats    *serviceStats
+	listener net.Listener
+	logger   *logger.Logger
+
+	conns  map[net.Conn]struct{}
+	mu     sync.Mutex
+	active sync.WaitGroup
+	closed bool
+}
+
+// newTCPForwarder binds the tunnel address and returns a forwarder ready to serve
+func newTCPForwarder(addr string, config *forwardConfig, stats *serviceStats, log *logger.Logger) (*tcpForwarder, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	f := &tcpForwarder{
+		addr:     addr,
+		stats:    stats,
+		listener: ln,
+		logger:   log,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	f.config.Store(config)
+	return f, nil
+}
+
+// reconfigure replaces the configuration used for new connections. Open
+// connections keep theirs.
+func (f *tcpForwarder) reconfigure(config *forwardConfig) {
+	f.config.Store(config)
+}
+
+// Address returns the address the forwarder listens on
+func (f *tcpForwarder) Address() string {
+	return f.addr
+}
+
+// Serve accepts tunnel connections until the listener is closed
+func (f *tcpForwarder) Serve() error {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		config := f.config.Load()
+		if !config.access.allows(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		ip := clientIP(conn.RemoteAddr().String())
+		if ok, _ := config.limiter.admit(ip); !ok {
+			f.stats.limited.Add(1)
+			f.logger.Debug("[Proxy] %s -> rate limited client %s", config.name, ip)
+			conn.Close()
+			continue
+		}
+
+		// Only connections past the limits count as uses
+		if !config.lifetime.use() {
+			config.limiter.release(ip)
+			conn.Close()
+			continue
+		}
+
+		if !f.track(conn) {
+			config.limiter.release(ip)
+			conn.Close()
+			return nil
+		}
+
+		go f.handle(conn, config)
+	}
+}
+
+// track registers a client connection, returning false if the forwarder is closed
+func (f *tcpForwarder) track(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	f.active.Add(1)
+	return true
+}
+
+// untrack removes a finished client connection
+func (f *tcpForwarder) untrack(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, conn)
+	f.mu.Unlock()
+	f.active.Done()
+}
+
+// handle pipes bytes between a tunnel client and the upstream target
+func (f *tcpForwarder) handle(client net.Conn, config *forwardConfig) {
+	defer config.limiter.release(clientIP(client.RemoteAddr().String()))
+	defer f.untrack(client)
+	defer client.Close()
+
+	upstream, target, err := f.dial(config.lb)
+	if err != nil {
+		f.logger.Printf("TCP proxy error for service %s: %v", config.name, err)
+		return
+	}
+	defer config.lb.release(target)
+	defer upstream.Close()
+
+	f.logger.Debug("[Proxy] %s -> TCP %s connected to %s", config.name, client.RemoteAddr(), target.addr)
+
+	f.stats.activeSessions.Add(1)
+	f.stats.totalSessions.Add(1)
+	defer f.stats.activeSessions.Add(-1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		f.stats.bytesIn.Add(pipeTCP(upstream, client))
+		done <- struct{}{}
+	}()
+	go func() {
+		f.stats.bytesOut.Add(pipeTCP(client, upstream))
+		done <- struct{}{}
+	}()
+
+	// Wait for both directions so half-closed protocols can finish their exchange
+	<-done
+	<-done
+}
+
+// dial connects to an upstream target of lb, trying the next one when a dial
+// fails. The returned target must be released once the connection is done.
+func (f *tcpForwarder) dial(lb *balancer) (net.Conn, *upstreamTarget, error) {
+	var tried []*upstreamTarget
+	var lastErr error
+
+	for {
+		target := lb.acquire(tried...)
+		if target == nil {
+			return nil, nil, lastErr
+		}
+
+		conn, err := net.DialTimeout("tcp", target.addr, tcpDialTimeout)
+		if err == nil {
+			lb.markSuccess(target)
+			return conn, target, nil
+		}
+
+		f.stats.upstreamErrors.Add(1)
+		lb.release(target)
+		lb.markFailure(target, err)
+		tried = append(tried, target)
+		lastErr = err
+	}
+}
+
+// pipeTCP copies src to dst, half-closing dst once src reaches EOF, and
+// returns the number of bytes copied
+func pipeTCP(dst, src net.Conn) int64 {
+	n, _ := io.Copy(dst, src)
+	if tcpConn, ok := dst.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+	return n
+}
+
+// Shutdown stops accepting connections and waits for open ones to finish
+// until the context expires
+func (f *tcpForwarder) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		f.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the listener and drops all open connections immediately
+func (f *tcpForwarder) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+
+	return f.listener.Close()
+}
+
+// startTCPForwardService starts raw TCP forwarding on a specific address
+func (p *ProxyProvider) startTCPForwardService(ctx context.Context, service *models.ProxyService, addr string) error {
+	config := p.forwardConfigFor(service)
+
+	forwarder, err := newTCPForwarder(addr, config, p.statsFor(service.ID), p.logger)
+	if err != nil {
+		return err
+	}
+
+	p.registerServer(service.ID, addr, forwarder)
+
+	p.wg.Add(2)
+
+	go func() {
+		defer p.wg.Done()
+		p.logger.Printf("Starting TCP proxy service %s on %s -> %s", service.Name, addr, config.lb.describe())
+		if err := forwarder.Serve(); err != nil {
+			p.logger.Printf("Proxy server error for %s: %v", service.Name, err)
+		}
+	}()
+
+	go func() {
+		defer p.wg.Done()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := forwarder.Shutdown(shutdownCtx); err != nil {
+			p.logger.Printf("Force closing server for %s: %v", service.Name, err)
+			forwarder.Close()
+		}
+
+		p.logger.Printf("Stopped TCP proxy service %s on %s", service.Name, addr)
+	}()
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// startTCPEcho starts a TCP upstream echoing what it reads until the client
+// half-closes, and returns its port
+func startTCPEcho(t *testing.T) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// freeTCPPort returns a port free on the loopback address
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// exchangeTCP sends payload over a new connection to addr, half-closes it
+// and returns everything read back
+func exchangeTCP(addr string, payload []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	go func() {
+		conn.Write(payload)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	return io.ReadAll(conn)
+}
+
+func TestTCPForwarding(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{
+		ID:        "svc",
+		Name:      "test",
+		Protocol:  models.ProtocolTCP,
+		LocalHost: "127.0.0.1",
+		LocalPort: startTCPEcho(t),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr := fmt.Sprintf("127.0.0.1:%d", freeTCPPort(t))
+	if err := p.startTCPForwardService(ctx, service, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{name: "small", payload: []byte("ping")},
+		{name: "empty", payload: []byte{}},
+		{name: "large", payload: bytes.Repeat([]byte("0123456789abcdef"), 1<<16)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exchangeTCP(addr, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.payload) {
+				t.Fatalf("echoed %d bytes, want %d", len(got), len(tt.payload))
+			}
+		})
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				payload := []byte(strings.Repeat(string(rune('a'+i)), 1000))
+				got, err := exchangeTCP(addr, payload)
+				if err != nil || !bytes.Equal(got, payload) {
+					t.Errorf("connection %d: echoed %d bytes, %v", i, len(got), err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	cancel()
+	p.wg.Wait()
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("forwarder still accepting after shutdown")
+	}
+}
+
+func TestTCPForwardingUpstreamDown(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{
+		ID:        "svc",
+		Name:      "test",
+		Protocol:  models.ProtocolTCP,
+		LocalHost: "127.0.0.1",
+		LocalPort: freeTCPPort(t),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		p.wg.Wait()
+	}()
+	addr := fmt.Sprintf("127.0.0.1:%d", freeTCPPort(t))
+	if err := p.startTCPForwardService(ctx, service, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := exchangeTCP(addr, []byte("ping"))
+	if err == nil && len(got) != 0 {
+		t.Fatalf("read %q from an unreachable upstream", got)
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// serviceTimeouts are the resolved timeouts of an HTTP service. Zero means no
+// timeout.
+type serviceTimeouts struct {
+	readHeader     time.Duration
+	read           time.Duration
+	write          time.Duration
+	idle           time.Duration
+	upstreamHeader time.Duration
+	flushInterval  time.Duration // negative flushes after every write
+}
+
+// timeoutProfiles are the defaults each profile starts from
+var timeoutProfiles = map[string]serviceTimeouts{
+	models.TimeoutProfileStandard: {
+		readHeader:     10 * time.Second,
+		read:           30 * time.Second,
+		write:          30 * time.Second,
+		idle:           120 * time.Second,
+		upstreamHeader: 30 * time.Second,
+	},
+	models.TimeoutProfileStreaming: {
+		readHeader:     10 * time.Second,
+		idle:           120 * time.Second,
+		upstreamHeader: 60 * time.Second,
+		flushInterval:  -1,
+	},
+}
+
+// timeoutProfile returns the profile a service uses
+func timeoutProfile(service *models.ProxyService) string {
+	if service.TimeoutProfile != "" {
+		return service.TimeoutProfile
+	}
+	if strings.ToLower(service.Protocol) == models.ProtocolWebSocket {
+		return models.TimeoutProfileStreaming
+	}
+	return models.TimeoutProfileStandard
+}
+
+// resolveTimeouts applies a service's timeout overrides to its profile
+func resolveTimeouts(service *models.ProxyService) serviceTimeouts {
+	t, exists := timeoutProfiles[timeoutProfile(service)]
+	if !exists {
+		t = timeoutProfiles[models.TimeoutProfileStandard]
+	}
+
+	overrideSeconds(&t.readHeader, service.ReadHeaderTimeout)
+	overrideSeconds(&t.idle, service.IdleTimeout)
+	overrideSeconds(&t.upstreamHeader, service.UpstreamHeaderTimeout)
+	if service.WriteTimeout != 0 {
+		// The write timeout bounds the whole exchange, request body included
+		overrideSeconds(&t.write, service.WriteTimeout)
+		t.read = t.write
+	}
+
+	switch {
+	case service.FlushInterval < 0:
+		t.flushInterval = -1
+	case service.FlushInterval > 0:
+		t.flushInterval = time.Duration(service.FlushInterval) * time.Millisecond
+	}
+
+	return t
+}
+
+// overrideSeconds replaces d with a timeout in seconds: 0 keeps d, -1
+// disables it
+func overrideSeconds(d *time.Duration, seconds int) {
+	switch {
+	case seconds < 0:
+		*d = 0
+	case seconds > 0:
+		*d = time.Duration(seconds) * time.Second
+	}
+}
+
+// withDeadlines applies the read and write timeouts to each request. They are
+// set per request rather than on the http.Server so services sharing the
+// virtual host listeners keep their own.
+func (t serviceTimeouts) withDeadlines(next http.Handler) http.Handler {
+	if t.read == 0 && t.write == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		now := time.Now()
+		if t.read > 0 {
+			_ = rc.SetReadDeadline(now.Add(t.read))
+		}
+		if t.write > 0 {
+			_ = rc.SetWriteDeadline(now.Add(t.write))
+			// Don't let the deadline carry over to the next request on a
+			// keep-alive connection
+			defer rc.SetWriteDeadline(time.Time{})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,310 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+const (
+	// udpSessionIdleTimeout is how long a client session lives without traffic
+	udpSessionIdleTimeout = 60 * time.Second
+	// udpMaxDatagramSize is the largest datagram the forwarder relays
+	udpMaxDatagramSize = 64 * 1024
+)
+
+// udpSession maps one tunnel client address to its own upstream socket
+type udpSession struct {
+	client   net.Addr
+	lb       *balancer      // the balancer target was acquired from
+	limiter  *clientLimiter // the limiter holding the client's session slot
+	target   *upstreamTarget
+	upstream *net.UDPConn
+	lastSeen atomic.Int64 // unix nanoseconds
+}
+
+// touch records activity on the session
+func (s *udpSession) touch() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idleSince returns how long the session has been without traffic
+func (s *udpSession) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, s.lastSeen.Load()))
+}
+
+// udpForwarder relays datagrams from a tunnel address to a LAN target, giving
+// each client its own upstream socket so replies can be routed back
+type udpForwarder struct {
+	addr   string
+	config atomic.Pointer[forwardConfig]
+	conn   net.PacketConn
+	logger *logger.Logger
+	stats  *serviceStats
+
+	sessions map[string]*udpSession
+	mu       sync.Mutex
+	closed   bool
+	done     chan struct{}
+}
+
+// newUDPForwarder binds the tunnel address and returns a forwarder ready to serve
+func newUDPForwarder(addr string, config *forwardConfig, stats *serviceStats, log *logger.Logger) (*udpForwarder, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	f := &udpForwarder{
+		addr:     addr,
+		conn:     conn,
+		logger:   log,
+		stats:    stats,
+		sessions: make(map[string]*udpSession),
+		done:     make(chan struct{}),
+	}
+	f.config.Store(config)
+	return f, nil
+}
+
+// reconfigure replaces the configuration used for new sessions. Open
+// sessions keep their upstream target.
+func (f *udpForwarder) reconfigure(config *forwardConfig) {
+	f.config.Store(config)
+}
+
+// Address returns the address the forwarder listens on
+func (f *udpForwarder) Address() string {
+	return f.addr
+}
+
+// Serve relays client datagrams until the listener is closed
+func (f *udpForwarder) Serve() error {
+	go f.expireSessions()
+
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		n, client, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		config := f.config.Load()
+		if !config.access.allows(client) {
+			continue
+		}
+
+		session, err := f.session(client, config)
+		if errors.Is(err, errServiceExpired) {
+			continue
+		}
+		if errors.Is(err, errClientLimited) {
+			f.stats.limited.Add(1)
+			f.logger.Debug("[Proxy] %s -> rate limited client %s", config.name, clientIP(client.String()))
+			continue
+		}
+		if err != nil {
+			f.logger.Printf("UDP proxy error for service %s: %v", config.name, err)
+			continue
+		}
+		if session == nil {
+			return nil
+		}
+
+		session.touch()
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			f.logger.Debug("[Proxy] %s -> UDP write to %s failed: %v", config.name, session.target.addr, err)
+			continue
+		}
+		f.stats.bytesIn.Add(int64(n))
+	}
+}
+
+// session returns the session for a client, dialing a new upstream socket if
+// needed. New sessions are subject to the client limits, each open session
+// holding one connection slot. It returns nil without error once the
+// forwarder is closed.
+func (f *udpForwarder) session(client net.Addr, config *forwardConfig) (*udpSession, error) {
+	key := client.String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, nil
+	}
+	if session, exists := f.sessions[key]; exists {
+		return session, nil
+	}
+
+	ip := clientIP(key)
+	if ok, _ := config.limiter.admit(ip); !ok {
+		return nil, errClientLimited
+	}
+	if !config.lifetime.use() {
+		config.limiter.release(ip)
+		return nil, errServiceExpired
+	}
+
+	target := config.lb.acquire()
+	conn, err := net.Dial("udp", target.addr)
+	if err != nil {
+		f.stats.upstreamErrors.Add(1)
+		config.lb.release(target)
+		config.lb.markFailure(target, err)
+		config.limiter.release(ip)
+		return nil, fmt.Errorf("failed to dial upstream %s: %w", target.addr, err)
+	}
+
+	session := &udpSession{
+		client:   client,
+		lb:       config.lb,
+		limiter:  config.limiter,
+		target:   target,
+		upstream: conn.(*net.UDPConn),
+	}
+	session.touch()
+	f.sessions[key] = session
+
+	f.stats.activeSessions.Add(1)
+	f.stats.totalSessions.Add(1)
+	f.logger.Debug("[Proxy] %s -> UDP session opened for %s", config.name, key)
+
+	go f.relayReplies(key, session)
+
+	return session, nil
+}
+
+// relayReplies copies upstream replies back to the session's client
+func (f *udpForwarder) relayReplies(key string, session *udpSession) {
+	defer f.removeSession(key, session)
+
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			return
+		}
+
+		session.touch()
+		if _, err := f.conn.WriteTo(buf[:n], session.client); err != nil {
+			return
+		}
+		f.stats.bytesOut.Add(int64(n))
+	}
+}
+
+// removeSession closes a session if it is still registered under key
+func (f *udpForwarder) removeSession(key string, session *udpSession) {
+	f.mu.Lock()
+	current, exists := f.sessions[key]
+	if exists && current == session {
+		delete(f.sessions, key)
+	}
+	f.mu.Unlock()
+
+	if exists && current == session {
+		session.upstream.Close()
+		session.lb.release(session.target)
+		session.limiter.release(clientIP(key))
+		f.stats.activeSessions.Add(-1)
+		f.logger.Debug("[Proxy] %s -> UDP session closed for %s", f.config.Load().name, key)
+	}
+}
+
+// expireSessions periodically closes sessions that have been idle too long
+func (f *udpForwarder) expireSessions() {
+	ticker := time.NewTicker(udpSessionIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case now := <-ticker.C:
+			f.mu.Lock()
+			expired := make(map[string]*udpSession)
+			for key, session := range f.sessions {
+				if session.idleSince(now) > udpSessionIdleTimeout {
+					expired[key] = session
+				}
+			}
+			f.mu.Unlock()
+
+			for key, session := range expired {
+				f.removeSession(key, session)
+			}
+		}
+	}
+}
+
+// Shutdown stops the forwarder. UDP has no in-flight requests to drain, so
+// this is equivalent to Close.
+func (f *udpForwarder) Shutdown(ctx context.Context) error {
+	return f.Close()
+}
+
+// Close stops the listener and closes every client session
+func (f *udpForwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	sessions := make(map[string]*udpSession, len(f.sessions))
+	for key, session := range f.sessions {
+		sessions[key] = session
+	}
+	f.mu.Unlock()
+
+	for key, session := range sessions {
+		f.removeSession(key, session)
+	}
+
+	return f.conn.Close()
+}
+
+// startUDPForwardService starts UDP datagram forwarding on a specific address
+func (p *ProxyProvider) startUDPForwardService(ctx context.Context, service *models.ProxyService, addr string) error {
+	config := p.forwardConfigFor(service)
+
+	forwarder, err := newUDPForwarder(addr, config, p.statsFor(service.ID), p.logger)
+	if err != nil {
+		return err
+	}
+
+	p.registerServer(service.ID, addr, forwarder)
+
+	p.wg.Add(2)
+
+	go func() {
+		defer p.wg.Done()
+		p.logger.Printf("Starting UDP proxy service %s on %s -> %s", service.Name, addr, config.lb.describe())
+		if err := forwarder.Serve(); err != nil {
+			p.logger.Printf("Proxy server error for %s: %v", service.Name, err)
+		}
+	}()
+
+	go func() {
+		defer p.wg.Done()
+		<-ctx.Done()
+
+		forwarder.Close()
+
+		p.logger.Printf("Stopped UDP proxy service %s on %s", service.Name, addr)
+	}()
+
+	return nil
+}
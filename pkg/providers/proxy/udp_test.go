@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// startUDPEcho starts a UDP upstream answering each datagram with its payload
+// and the address it came from, and returns its port
+func startUDPEcho(t *testing.T) int {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, udpMaxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(string(buf[:n])+" from "+addr.String()), addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// exchangeUDP sends payload from conn to addr and returns the reply
+func exchangeUDP(t *testing.T, conn net.PacketConn, addr net.Addr, payload string) string {
+	t.Helper()
+
+	if _, err := conn.WriteTo([]byte(payload), addr); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, udpMaxDatagramSize)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reply to %q: %v", payload, err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPForwarding(t *testing.T) {
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	service := &models.ProxyService{
+		ID:        "svc",
+		Name:      "test",
+		Protocol:  models.ProtocolUDP,
+		LocalHost: "127.0.0.1",
+		LocalPort: startUDPEcho(t),
+	}
+
+	// A UDP port free now, bound by the forwarder right after
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr()
+	probe.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.startUDPForwardService(ctx, service, addr.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]net.PacketConn, 2)
+	for i := range clients {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients[i] = conn
+	}
+
+	// Each client gets its own upstream socket, kept for its session
+	sources := make([]string, len(clients))
+	for i, client := range clients {
+		for round := range 3 {
+			payload := fmt.Sprintf("client %d round %d", i, round)
+			reply := exchangeUDP(t, client, addr, payload)
+
+			echoed, source, ok := strings.Cut(reply, " from ")
+			if !ok || echoed != payload {
+				t.Fatalf("reply %q to %q", reply, payload)
+			}
+			if round == 0 {
+				sources[i] = source
+			} else if source != sources[i] {
+				t.Fatalf("client %d moved from upstream socket %s to %s", i, sources[i], source)
+			}
+		}
+	}
+	if sources[0] == sources[1] {
+		t.Fatalf("clients share upstream socket %s", sources[0])
+	}
+
+	cancel()
+	p.wg.Wait()
+	if conn, err := net.ListenPacket("udp", addr.String()); err != nil {
+		t.Fatalf("tunnel port still bound after shutdown: %v", err)
+	} else {
+		conn.Close()
+	}
+}
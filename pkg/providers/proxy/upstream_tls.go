@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/utils"
+)
+
+// upstreamScheme returns the URL scheme used to reach a service's upstream
+func upstreamScheme(service *models.ProxyService) string {
+	if service.UpstreamTLS != models.UpstreamTLSNone {
+		return "https"
+	}
+	return "http" // WebSocket upgrades start as HTTP
+}
+
+// upstreamTransport builds the HTTP transport for a service's upstream,
+// applying its TLS verification mode and response header timeout
+func upstreamTransport(service *models.ProxyService) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = resolveTimeouts(service).upstreamHeader
+
+	if service.UpstreamTLS == models.UpstreamTLSNone {
+		return transport, nil
+	}
+
+	tlsConfig, err := upstreamTLSConfig(service)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// upstreamTLSConfig builds the client TLS configuration for a service's upstream
+func upstreamTLSConfig(service *models.ProxyService) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: service.UpstreamSNI,
+	}
+
+	switch service.UpstreamTLS {
+	case models.UpstreamTLSSystem:
+		// Default verification against the system roots
+
+	case models.UpstreamTLSCustomCA:
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(service.UpstreamCA)) {
+			return nil, fmt.Errorf("no valid upstream CA certificate configured")
+		}
+		tlsConfig.RootCAs = pool
+
+	case models.UpstreamTLSFingerprint:
+		if service.UpstreamFingerprint == "" {
+			return nil, fmt.Errorf("no upstream certificate fingerprint configured")
+		}
+		// Chain verification is replaced by comparing the leaf certificate digest
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyFingerprint(service.UpstreamFingerprint)
+
+	case models.UpstreamTLSInsecure:
+		tlsConfig.InsecureSkipVerify = true
+
+	default:
+		return nil, fmt.Errorf("unsupported upstream TLS mode: %s", service.UpstreamTLS)
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyFingerprint returns a certificate check that accepts only a leaf
+// certificate whose SHA-256 digest matches the pinned fingerprint
+func verifyFingerprint(fingerprint string) func([][]byte, [][]*x509.Certificate) error {
+	fingerprint = utils.NormalizeFingerprint(fingerprint)
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("upstream presented no certificate")
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		if got := hex.EncodeToString(sum[:]); got != fingerprint {
+			return fmt.Errorf("upstream certificate fingerprint mismatch: got %s", got)
+		}
+		return nil
+	}
+}
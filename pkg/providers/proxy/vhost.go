@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+)
+
+// vhostServerID is the key prefix the shared virtual host listeners are tracked under
+const vhostServerID = "vhost"
+
+// isVHostService reports whether a service can be reached through the shared
+// virtual host listeners
+func isVHostService(service *models.ProxyService) bool {
+	if service.Hostname == "" {
+		return false
+	}
+	switch strings.ToLower(service.Protocol) {
+	case models.ProtocolHTTP, models.ProtocolWebSocket:
+		return true
+	default:
+		return false
+	}
+}
+
+// registerHostname routes a service's hostname on the shared listeners,
+// replacing any hostname it was routed under before
+func (p *ProxyProvider) registerHostname(service *models.ProxyService) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for hostname, serviceID := range p.hostnames {
+		if serviceID == service.ID {
+			delete(p.hostnames, hostname)
+		}
+	}
+	if isVHostService(service) {
+		p.hostnames[service.Hostname] = service.ID
+	}
+}
+
+// unregisterHostnames removes every hostname routed to a service
+func (p *ProxyProvider) unregisterHostnames(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for hostname, serviceID := range p.hostnames {
+		if serviceID == id {
+			delete(p.hostnames, hostname)
+		}
+	}
+}
+
+// isKnownHostname reports whether a hostname is routed on the shared listeners
+func (p *ProxyProvider) isKnownHostname(hostname string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, exists := p.hostnames[hostname]
+	return exists
+}
+
+// servesHostname reports whether a hostname is routed to a service
+func (p *ProxyProvider) servesHostname(hostname, id string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.hostnames[hostname] == id
+}
+
+// handlerForHost returns the handler of the service routed for a hostname
+func (p *ProxyProvider) handlerForHost(hostname string) http.Handler {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	id, exists := p.hostnames[hostname]
+	if !exists {
+		return nil
+	}
+	handler, exists := p.handlers[id]
+	if !exists {
+		return nil
+	}
+	return handler
+}
+
+// serveVHost routes a request on a shared listener by its Host header, falling
+// back to the TLS server name
+func (p *ProxyProvider) serveVHost(w http.ResponseWriter, r *http.Request) {
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	if hostname == "" && r.TLS != nil {
+		hostname = r.TLS.ServerName
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	handler := p.handlerForHost(hostname)
+	if handler == nil {
+		http.Error(w, "Unknown host", http.StatusNotFound)
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// startVHostListeners starts the shared HTTP and HTTPS virtual host listeners
+// on an interface, if they are enabled in the configuration
+func (p *ProxyProvider) startVHostListeners(ctx context.Context, ip string) {
+	if p.cfg == nil {
+		return
+	}
+
+	if p.cfg.VHostHTTPPort > 0 {
+		p.startVHostListener(ctx, fmt.Sprintf("%s:%d", ip, p.cfg.VHostHTTPPort), false)
+	}
+	if p.cfg.VHostHTTPSPort > 0 {
+		p.startVHostListener(ctx, fmt.Sprintf("%s:%d", ip, p.cfg.VHostHTTPSPort), true)
+	}
+}
+
+// startVHostListener starts one shared virtual host listener
+func (p *ProxyProvider) startVHostListener(ctx context.Context, addr string, useTLS bool) {
+	// Services apply their own read and write deadlines per request
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(p.serveVHost),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnContext:       withConnUses,
+	}
+
+	scheme := "HTTP"
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		server.TLSConfig = p.serverTLSConfig(host, p.isKnownHostname)
+		scheme = "HTTPS"
+	}
+
+	p.registerServer(vhostServerID, addr, httpTunnelServer{server})
+
+	p.logger.Printf("Starting %s virtual host listener on %s", scheme, addr)
+	p.runHTTPServer(ctx, server, scheme+" virtual host listener")
+}
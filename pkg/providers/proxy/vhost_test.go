@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"github.com/tphan267/arqut-edge-ce/pkg/storage/repositories"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestVHostProvider returns a provider routing a.example and b.example to
+// handlers answering with the service ID
+func newTestVHostProvider(t *testing.T) *ProxyProvider {
+	t.Helper()
+
+	p := NewProxyProvider()
+	p.logger = newTestLogger()
+	for _, service := range []*models.ProxyService{
+		{ID: "svc-a", Protocol: models.ProtocolHTTP, Hostname: "a.example"},
+		{ID: "svc-b", Protocol: models.ProtocolWebSocket, Hostname: "b.example"},
+		{ID: "svc-tcp", Protocol: models.ProtocolTCP, Hostname: "tcp.example"},
+	} {
+		id := service.ID
+		p.handlers[id] = newSwappableHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, id)
+		}))
+		p.registerHostname(service)
+	}
+	return p
+}
+
+// newTestCA returns an edge CA on a fresh database
+func newTestCA(t *testing.T) *localCA {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := newLocalCA(repositories.NewCertificateRepository(db), "test-edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func TestVHostRoutesByHost(t *testing.T) {
+	p := newTestVHostProvider(t)
+	server := httptest.NewServer(http.HandlerFunc(p.serveVHost))
+	defer server.Close()
+
+	tests := []struct {
+		host       string
+		wantStatus int
+		wantBody   string
+	}{
+		{host: "a.example", wantStatus: http.StatusOK, wantBody: "svc-a"},
+		{host: "b.example:8443", wantStatus: http.StatusOK, wantBody: "svc-b"},
+		{host: "A.Example.", wantStatus: http.StatusOK, wantBody: "svc-a"},
+		{host: "c.example", wantStatus: http.StatusNotFound},
+		{host: "tcp.example", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestVHostFallsBackToServerName(t *testing.T) {
+	p := newTestVHostProvider(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = ""
+	req.TLS = &tls.ConnectionState{ServerName: "b.example"}
+	rec := httptest.NewRecorder()
+	p.serveVHost(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "svc-b" {
+		t.Fatalf("response = %d %q, want 200 %q", rec.Code, rec.Body.String(), "svc-b")
+	}
+}
+
+func TestVHostCertificateBySNI(t *testing.T) {
+	p := newTestVHostProvider(t)
+	p.ca = newTestCA(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(p.serveVHost))
+	server.TLS = p.serverTLSConfig("127.0.0.1", p.isKnownHostname)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(p.ca.CertificatePEM()))
+
+	tests := []struct {
+		serverName string
+		wantName   string // name the served certificate must be valid for
+		wantBody   string
+	}{
+		{serverName: "a.example", wantName: "a.example", wantBody: "svc-a"},
+		{serverName: "b.example", wantName: "b.example", wantBody: "svc-b"},
+		{serverName: "unknown.example", wantName: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:            roots,
+				ServerName:         tt.serverName,
+				InsecureSkipVerify: tt.wantBody == "",
+			}}}
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.serverName
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if err := resp.TLS.PeerCertificates[0].VerifyHostname(tt.wantName); err != nil {
+				t.Fatalf("certificate: %v", err)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRegisterHostnameReplacesPrevious(t *testing.T) {
+	p := newTestVHostProvider(t)
+
+	p.registerHostname(&models.ProxyService{ID: "svc-a", Protocol: models.ProtocolHTTP, Hostname: "renamed.example"})
+	if p.isKnownHostname("a.example") {
+		t.Error("previous hostname still routed")
+	}
+	if !p.servesHostname("renamed.example", "svc-a") {
+		t.Error("new hostname not routed to the service")
+	}
+
+	p.unregisterHostnames("svc-a")
+	if p.isKnownHostname("renamed.example") {
+		t.Error("hostname routed after unregistering")
+	}
+	if !p.servesHostname("b.example", "svc-b") {
+		t.Error("hostname of another service unregistered")
+	}
+}
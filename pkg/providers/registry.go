@@ -3,9 +3,12 @@ package providers
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
 	"github.com/tphan267/arqut-edge-ce/pkg/signaling"
 	"github.com/tphan267/arqut-edge-ce/pkg/storage"
 )
@@ -184,6 +187,20 @@ func (r *Registry) RegisterAllRoutes(router fiber.Router, authMiddlewares ...fib
 	r.logger.Info("Routes registered for %d services", len(r.services))
 }
 
+// CollectMetrics writes the metrics of the signaling client and of every
+// service that exposes any
+func (r *Registry) CollectMetrics(w *metrics.Writer) {
+	if r.sigClient != nil {
+		r.sigClient.CollectMetrics(w)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.services)) {
+		if collector, ok := r.services[name].(metrics.Collector); ok {
+			collector.CollectMetrics(w)
+		}
+	}
+}
+
 // GetAuth returns the auth service with type assertion
 func (r *Registry) GetAuth() (AuthProvider, error) {
 	service, err := r.Get("auth")
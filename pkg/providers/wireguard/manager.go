@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/pion/webrtc/v4"
 	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
 	"github.com/tphan267/arqut-edge-ce/pkg/signaling"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -534,6 +537,51 @@ func (m *Manager) GetConnectedPeers() []string {
 	return peers
 }
 
+// CollectMetrics implements metrics.Collector with the state and traffic of
+// every peer tunnel
+func (m *Manager) CollectMetrics(w *metrics.Writer) {
+	m.mutex.RLock()
+	conns := make(map[string]*WireGuardPeerToPeer, len(m.wgConns))
+	maps.Copy(conns, m.wgConns)
+	m.mutex.RUnlock()
+
+	peerIDs := slices.Sorted(maps.Keys(conns))
+
+	type peerMetrics struct {
+		id     string
+		state  webrtc.PeerConnectionState
+		rx, tx int64
+	}
+	peers := make([]peerMetrics, 0, len(peerIDs))
+	for _, id := range peerIDs {
+		state, rx, tx := conns[id].transferStats()
+		peers = append(peers, peerMetrics{id: id, state: state, rx: rx, tx: tx})
+	}
+
+	w.Family("arqut_wireguard_peers", "Peer tunnels currently set up.", metrics.TypeGauge)
+	w.Sample("arqut_wireguard_peers", float64(len(peers)))
+
+	w.Family("arqut_wireguard_peer_connected", "Whether the peer connection is up, labelled with its WebRTC state.", metrics.TypeGauge)
+	for _, peer := range peers {
+		connected := 0.0
+		if peer.state == webrtc.PeerConnectionStateConnected {
+			connected = 1
+		}
+		w.Sample("arqut_wireguard_peer_connected", connected,
+			metrics.L("peer", peer.id), metrics.L("state", peer.state.String()))
+	}
+
+	w.Family("arqut_wireguard_peer_received_bytes_total", "Bytes received through the peer tunnel.", metrics.TypeCounter)
+	for _, peer := range peers {
+		w.Sample("arqut_wireguard_peer_received_bytes_total", float64(peer.rx), metrics.L("peer", peer.id))
+	}
+
+	w.Family("arqut_wireguard_peer_sent_bytes_total", "Bytes sent through the peer tunnel.", metrics.TypeCounter)
+	for _, peer := range peers {
+		w.Sample("arqut_wireguard_peer_sent_bytes_total", float64(peer.tx), metrics.L("peer", peer.id))
+	}
+}
+
 // GetPeerInfo returns information about a specific peer
 func (m *Manager) GetPeerInfo(peerID string) (*PeerInfo, error) {
 	m.mutex.RLock()
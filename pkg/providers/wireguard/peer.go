@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -194,6 +195,40 @@ func (p *WireGuardPeerToPeer) setupWireGuardConn(peerConfig *PeerConfig, callBac
 	return nil
 }
 
+// transferStats returns the connection state and the bytes received from and
+// sent to the peer through its WireGuard device
+func (p *WireGuardPeerToPeer) transferStats() (webrtc.PeerConnectionState, int64, int64) {
+	p.mutex.RLock()
+	state := p.connSate
+	wgDevice := p.wgDevice
+	p.mutex.RUnlock()
+
+	if wgDevice == nil {
+		return state, 0, 0
+	}
+	config, err := wgDevice.IpcGet()
+	if err != nil {
+		return state, 0, 0
+	}
+
+	var rx, tx int64
+	for _, line := range strings.Split(config, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "rx_bytes":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			rx += n
+		case "tx_bytes":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			tx += n
+		}
+	}
+	return state, rx, tx
+}
+
 func (p *WireGuardPeerToPeer) cleanup() {
 	// Cleanup resources in reverse order of creation
 	if p.wgDevice != nil {
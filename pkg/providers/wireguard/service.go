@@ -7,6 +7,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/tphan267/arqut-edge-ce/pkg/api"
 	"github.com/tphan267/arqut-edge-ce/pkg/config"
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
 	"github.com/tphan267/arqut-edge-ce/pkg/providers"
 )
 
@@ -153,6 +154,13 @@ func (s *Service) RegisterAPIRoutes(router fiber.Router, middlewares ...fiber.Ha
 	s.registry.Logger().Printf("[WireGuard] API routes registered")
 }
 
+// CollectMetrics implements metrics.Collector
+func (s *Service) CollectMetrics(w *metrics.Writer) {
+	if s.manager != nil {
+		s.manager.CollectMetrics(w)
+	}
+}
+
 // GetManager returns the underlying WireGuard manager (for integration with other services)
 func (s *Service) GetManager() *Manager {
 	return s.manager
@@ -8,10 +8,12 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/tphan267/arqut-edge-ce/pkg/logger"
+	"github.com/tphan267/arqut-edge-ce/pkg/metrics"
 	"github.com/tphan267/arqut-edge-ce/pkg/utils"
 )
 
@@ -35,8 +37,9 @@ type Client struct {
 
 	logger *logger.Logger
 
-	reconnecting   bool
-	reconnectMutex sync.Mutex
+	reconnecting      bool
+	reconnectMutex    sync.Mutex
+	reconnectAttempts atomic.Int64
 }
 
 // NewClient creates a new signaling client with default API path "/api/v1"
@@ -345,6 +348,7 @@ func (c *Client) reconnect() {
 		}
 
 		c.logger.Printf("[Signaling] Reconnection attempt #%d...", attempt)
+		c.reconnectAttempts.Add(1)
 
 		if err := c.connectOnce(c.ctx); err != nil {
 			c.logger.Printf("[Signaling] Reconnect failed: %v (retrying in %v)", err, backoff)
@@ -392,6 +396,27 @@ func (c *Client) IsConnected() bool {
 	return c.conn != nil
 }
 
+// CollectMetrics implements metrics.Collector with the connection state and
+// outbound queue of the client
+func (c *Client) CollectMetrics(w *metrics.Writer) {
+	connected := 0.0
+	if c.IsConnected() {
+		connected = 1
+	}
+
+	w.Family("arqut_signaling_connected", "Whether the signaling connection is up.", metrics.TypeGauge)
+	w.Sample("arqut_signaling_connected", connected)
+
+	w.Family("arqut_signaling_reconnect_attempts_total", "Signaling reconnection attempts.", metrics.TypeCounter)
+	w.Sample("arqut_signaling_reconnect_attempts_total", float64(c.reconnectAttempts.Load()))
+
+	w.Family("arqut_signaling_outbound_queue_depth", "Messages waiting to be sent to the cloud.", metrics.TypeGauge)
+	w.Sample("arqut_signaling_outbound_queue_depth", float64(len(c.outboundChan)))
+
+	w.Family("arqut_signaling_outbound_queue_capacity", "Size of the outbound message queue.", metrics.TypeGauge)
+	w.Sample("arqut_signaling_outbound_queue_capacity", float64(cap(c.outboundChan)))
+}
+
 // OutboundChannel returns the send-only channel for outbound messages
 func (c *Client) OutboundChannel() chan<- *OutboundMessage {
 	return c.outboundChan
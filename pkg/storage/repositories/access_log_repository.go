@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"gorm.io/gorm"
+)
+
+type AccessLogRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessLogRepository(db *gorm.DB) *AccessLogRepository {
+	db.AutoMigrate(&models.AccessLogEntry{})
+	return &AccessLogRepository{db: db}
+}
+
+// AddEntries stores a batch of access log entries
+func (r *AccessLogRepository) AddEntries(entries []models.AccessLogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(entries, 100).Error
+}
+
+// GetEntries returns the newest entries matching a filter
+func (r *AccessLogRepository) GetEntries(filter models.AccessLogFilter) ([]models.AccessLogEntry, error) {
+	query := r.db.Where("service_id = ?", filter.ServiceID)
+
+	if !filter.From.IsZero() {
+		query = query.Where("time >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("time < ?", filter.To)
+	}
+	if filter.StatusMin > 0 {
+		query = query.Where("status >= ?", filter.StatusMin)
+	}
+	if filter.StatusMax > 0 {
+		query = query.Where("status <= ?", filter.StatusMax)
+	}
+	if filter.PathPrefix != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.PathPrefix)
+		query = query.Where(`path LIKE ? ESCAPE '\'`, escaped+"%")
+	}
+
+	var entries []models.AccessLogEntry
+	if err := query.Order("time DESC, id DESC").Limit(filter.Limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Prune deletes entries older than maxAge and keeps at most maxRows of the
+// newest ones
+func (r *AccessLogRepository) Prune(maxAge time.Duration, maxRows int) error {
+	if maxAge > 0 {
+		if err := r.db.Where("time < ?", time.Now().Add(-maxAge)).Delete(&models.AccessLogEntry{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if maxRows > 0 {
+		var cutoff models.AccessLogEntry
+		err := r.db.Select("id").Order("id DESC").Offset(maxRows).Limit(1).Find(&cutoff).Error
+		if err != nil {
+			return err
+		}
+		if cutoff.ID != 0 {
+			return r.db.Where("id <= ?", cutoff.ID).Delete(&models.AccessLogEntry{}).Error
+		}
+	}
+
+	return nil
+}
+
+// DeleteServiceEntries removes every entry of a service
+func (r *AccessLogRepository) DeleteServiceEntries(serviceID string) error {
+	return r.db.Where("service_id = ?", serviceID).Delete(&models.AccessLogEntry{}).Error
+}
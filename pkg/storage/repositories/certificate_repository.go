@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CertificateRepository struct {
+	db *gorm.DB
+}
+
+func NewCertificateRepository(db *gorm.DB) *CertificateRepository {
+	db.AutoMigrate(&models.Certificate{})
+	return &CertificateRepository{db: db}
+}
+
+// GetCertificate returns a stored certificate by name
+func (r *CertificateRepository) GetCertificate(name string) (*models.Certificate, error) {
+	var cert models.Certificate
+	if err := r.db.Where("name = ?", name).First(&cert).Error; err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// SaveCertificate inserts or replaces a certificate
+func (r *CertificateRepository) SaveCertificate(cert *models.Certificate) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(cert).Error
+}
+
+// DeleteCertificate deletes a certificate by name
+func (r *CertificateRepository) DeleteCertificate(name string) error {
+	return r.db.Where("name = ?", name).Delete(&models.Certificate{}).Error
+}
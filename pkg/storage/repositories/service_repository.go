@@ -1,38 +1,78 @@
 package repositories
 
 import (
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
 
 	"github.com/tphan267/arqut-edge-ce/pkg/models"
 	"github.com/tphan267/arqut-edge-ce/pkg/utils"
 	"gorm.io/gorm"
 )
 
+// ValidationError reports a service configuration rejected because of
+// invalid input, as opposed to a storage failure
+type ValidationError struct {
+	Err error
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// invalidf formats a ValidationError
+func invalidf(format string, args ...any) error {
+	return &ValidationError{Err: fmt.Errorf(format, args...)}
+}
+
 type ServiceRepository struct {
 	db *gorm.DB
 }
 
+// serviceChildTables are the tables holding per-service child rows, cleared
+// together with their service
+var serviceChildTables = []any{
+	&models.ProxyServiceRoute{},
+	&models.ProxyServiceUpstream{},
+	&models.ProxyServiceAccessRule{},
+	&models.ProxyServiceHeaderRule{},
+	&models.ProxyServiceErrorPage{},
+	&models.ProxyServiceSchedule{},
+}
+
 func NewServiceRepository(db *gorm.DB) *ServiceRepository {
-	db.AutoMigrate(&models.ProxyService{})
+	db.AutoMigrate(append([]any{&models.ProxyService{}}, serviceChildTables...)...)
 	return &ServiceRepository{db: db}
 }
 
 // AddService creates a new proxy service
 func (r *ServiceRepository) AddService(name, localHost string, localPort int, tunnelPort int, protocol string) (*models.ProxyService, error) {
 	// Validate protocol
-	if protocol != "http" && protocol != "websocket" {
-		return nil, fmt.Errorf("unsupported protocol: %s (supported: http, websocket)", protocol)
+	switch protocol {
+	case models.ProtocolHTTP, models.ProtocolWebSocket, models.ProtocolTCP, models.ProtocolUDP:
+	default:
+		return nil, invalidf("unsupported protocol: %s (supported: http, websocket, tcp, udp)", protocol)
 	}
 
 	// Validate input
 	if localPort < 1 || localPort > 65535 {
-		return nil, fmt.Errorf("invalid local port: %d", localPort)
+		return nil, invalidf("invalid local port: %d", localPort)
 	}
 	if localHost == "" {
-		return nil, fmt.Errorf("local host cannot be empty")
+		return nil, invalidf("local host cannot be empty")
 	}
 	if name == "" {
-		return nil, fmt.Errorf("service name cannot be empty")
+		return nil, invalidf("service name cannot be empty")
 	}
 
 	serviceID, _ := utils.GenerateID()
@@ -59,49 +99,780 @@ func (r *ServiceRepository) UpdateService(id string, config models.ProxyServiceC
 
 	if config.Name != nil {
 		if *config.Name == "" {
-			return fmt.Errorf("service name cannot be empty")
+			return invalidf("service name cannot be empty")
 		}
 		updates["name"] = *config.Name
 	}
 	if config.LocalHost != nil {
 		if *config.LocalHost == "" {
-			return fmt.Errorf("local host cannot be empty")
+			return invalidf("local host cannot be empty")
 		}
 		updates["local_host"] = *config.LocalHost
 	}
 	if config.LocalPort != nil {
 		if *config.LocalPort < 1 || *config.LocalPort > 65535 {
-			return fmt.Errorf("invalid local port: %d", *config.LocalPort)
+			return invalidf("invalid local port: %d", *config.LocalPort)
 		}
 		updates["local_port"] = *config.LocalPort
 	}
+	if config.TunnelPort != nil {
+		if *config.TunnelPort < 1 || *config.TunnelPort > 65535 {
+			return invalidf("invalid tunnel port: %d", *config.TunnelPort)
+		}
+		updates["tunnel_port"] = *config.TunnelPort
+	}
 	if config.Enabled != nil {
 		updates["enabled"] = *config.Enabled
 	}
+	if config.Hostname != nil {
+		hostname := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(*config.Hostname), "."))
+		if hostname != "" {
+			if !utils.IsValidHostname(hostname) {
+				return invalidf("invalid hostname: %s", hostname)
+			}
+			var count int64
+			if err := r.db.Model(&models.ProxyService{}).Where("hostname = ? AND id <> ?", hostname, id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return invalidf("hostname %s is already used by another service", hostname)
+			}
+		}
+		updates["hostname"] = hostname
+	}
+	if config.UpstreamTLS != nil || config.UpstreamCA != nil || config.UpstreamFingerprint != nil {
+		if err := r.upstreamTLSUpdates(id, config, updates); err != nil {
+			return err
+		}
+	}
+	if config.UpstreamSNI != nil {
+		updates["upstream_sni"] = *config.UpstreamSNI
+	}
+	if config.TLSEnabled != nil {
+		updates["tls_enabled"] = *config.TLSEnabled
+	}
+
+	if config.LoadBalancing != nil {
+		switch *config.LoadBalancing {
+		case "", models.LoadBalanceRoundRobin, models.LoadBalanceLeastConn, models.LoadBalanceWeighted:
+		default:
+			return invalidf("unsupported load balancing policy: %s", *config.LoadBalancing)
+		}
+		updates["load_balancing"] = *config.LoadBalancing
+	}
+
+	if config.HealthCheck != nil {
+		switch *config.HealthCheck {
+		case models.HealthCheckNone, models.HealthCheckHTTP, models.HealthCheckTCP:
+		default:
+			return invalidf("unsupported health check type: %s", *config.HealthCheck)
+		}
+		updates["health_check"] = *config.HealthCheck
+	}
+	if config.HealthCheckPath != nil {
+		if *config.HealthCheckPath != "" && !strings.HasPrefix(*config.HealthCheckPath, "/") {
+			return invalidf("health check path must start with /")
+		}
+		updates["health_check_path"] = *config.HealthCheckPath
+	}
+	if config.HealthCheckInterval != nil {
+		if *config.HealthCheckInterval < 0 || *config.HealthCheckInterval > 3600 {
+			return invalidf("health check interval must be between 0 (default) and 3600 seconds")
+		}
+		updates["health_check_interval"] = *config.HealthCheckInterval
+	}
+	if config.HealthCheckTimeout != nil {
+		if *config.HealthCheckTimeout < 0 || *config.HealthCheckTimeout > 60 {
+			return invalidf("health check timeout must be between 0 (default) and 60 seconds")
+		}
+		updates["health_check_timeout"] = *config.HealthCheckTimeout
+	}
+	if config.HealthCheckStatus != nil {
+		if *config.HealthCheckStatus != 0 && (*config.HealthCheckStatus < 100 || *config.HealthCheckStatus > 599) {
+			return invalidf("invalid health check status: %d", *config.HealthCheckStatus)
+		}
+		updates["health_check_status"] = *config.HealthCheckStatus
+	}
+	if config.HealthyThreshold != nil {
+		if *config.HealthyThreshold < 0 || *config.HealthyThreshold > 10 {
+			return invalidf("healthy threshold must be between 0 (default) and 10")
+		}
+		updates["healthy_threshold"] = *config.HealthyThreshold
+	}
+	if config.UnhealthyThreshold != nil {
+		if *config.UnhealthyThreshold < 0 || *config.UnhealthyThreshold > 10 {
+			return invalidf("unhealthy threshold must be between 0 (default) and 10")
+		}
+		updates["unhealthy_threshold"] = *config.UnhealthyThreshold
+	}
+
+	if config.AuthMode != nil || config.AuthUsername != nil || config.AuthSecret != nil {
+		if err := r.authUpdates(id, config, updates); err != nil {
+			return err
+		}
+	}
+
+	if config.TimeoutProfile != nil {
+		switch *config.TimeoutProfile {
+		case "", models.TimeoutProfileStandard, models.TimeoutProfileStreaming:
+		default:
+			return invalidf("unsupported timeout profile: %s", *config.TimeoutProfile)
+		}
+		updates["timeout_profile"] = *config.TimeoutProfile
+	}
+	timeouts := []struct {
+		column string
+		value  *int
+	}{
+		{"read_header_timeout", config.ReadHeaderTimeout},
+		{"idle_timeout", config.IdleTimeout},
+		{"write_timeout", config.WriteTimeout},
+		{"upstream_header_timeout", config.UpstreamHeaderTimeout},
+		{"flush_interval", config.FlushInterval},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value == nil {
+			continue
+		}
+		if *timeout.value < -1 {
+			return invalidf("invalid %s: %d", strings.ReplaceAll(timeout.column, "_", " "), *timeout.value)
+		}
+		updates[timeout.column] = *timeout.value
+	}
+
+	if config.RateLimit != nil {
+		if *config.RateLimit < 0 {
+			return invalidf("rate limit cannot be negative")
+		}
+		updates["rate_limit"] = *config.RateLimit
+	}
+	if config.RateBurst != nil {
+		if *config.RateBurst < 0 {
+			return invalidf("rate burst cannot be negative")
+		}
+		updates["rate_burst"] = *config.RateBurst
+	}
+	if config.ConnLimit != nil {
+		if *config.ConnLimit < 0 {
+			return invalidf("connection limit cannot be negative")
+		}
+		updates["conn_limit"] = *config.ConnLimit
+	}
+
+	if config.BreakerThreshold != nil {
+		if *config.BreakerThreshold < 0 {
+			return invalidf("breaker threshold cannot be negative")
+		}
+		updates["breaker_threshold"] = *config.BreakerThreshold
+	}
+	if config.BreakerCooldown != nil {
+		if *config.BreakerCooldown < 0 || *config.BreakerCooldown > models.MaxBreakerCooldown {
+			return invalidf("breaker cooldown must be between 0 and %d seconds", models.MaxBreakerCooldown)
+		}
+		updates["breaker_cooldown"] = *config.BreakerCooldown
+	}
+	if config.RetryAttempts != nil {
+		if *config.RetryAttempts < 0 || *config.RetryAttempts > models.MaxRetryAttempts {
+			return invalidf("retry attempts must be between 0 and %d", models.MaxRetryAttempts)
+		}
+		updates["retry_attempts"] = *config.RetryAttempts
+	}
+
+	if config.ShadowHost != nil || config.ShadowPort != nil || config.ShadowPercent != nil {
+		if err := r.shadowUpdates(id, config, updates); err != nil {
+			return err
+		}
+	}
+
+	if config.CaptureEnabled != nil {
+		updates["capture_enabled"] = *config.CaptureEnabled
+	}
+	if config.CaptureSize != nil {
+		if *config.CaptureSize < 0 || *config.CaptureSize > models.MaxCaptureSize {
+			return invalidf("capture size must be between 0 and %d", models.MaxCaptureSize)
+		}
+		updates["capture_size"] = *config.CaptureSize
+	}
+
+	if config.Compression != nil {
+		updates["compression"] = *config.Compression
+	}
+	if config.CompressionTypes != nil {
+		types, err := normalizeMIMETypes(*config.CompressionTypes)
+		if err != nil {
+			return err
+		}
+		updates["compression_types"] = types
+	}
+	if config.CompressionMinSize != nil {
+		if *config.CompressionMinSize < 0 {
+			return invalidf("compression minimum size cannot be negative")
+		}
+		updates["compression_min_size"] = *config.CompressionMinSize
+	}
+
+	if config.CacheEnabled != nil {
+		updates["cache_enabled"] = *config.CacheEnabled
+	}
+	if config.CacheSizeMB != nil {
+		if *config.CacheSizeMB < 0 || *config.CacheSizeMB > models.MaxCacheSizeMB {
+			return invalidf("cache size must be between 0 and %d MB", models.MaxCacheSizeMB)
+		}
+		updates["cache_size_mb"] = *config.CacheSizeMB
+	}
+
+	if config.Maintenance != nil {
+		updates["maintenance"] = *config.Maintenance
+	}
+	if config.MaintenanceRetryAfter != nil {
+		if *config.MaintenanceRetryAfter < 0 || *config.MaintenanceRetryAfter > models.MaxMaintenanceRetryAfter {
+			return invalidf("maintenance retry after must be between 0 and %d seconds", models.MaxMaintenanceRetryAfter)
+		}
+		updates["maintenance_retry_after"] = *config.MaintenanceRetryAfter
+	}
+
+	var routes []models.ProxyServiceRoute
+	if config.Routes != nil {
+		var err error
+		if routes, err = normalizeRoutes(id, *config.Routes); err != nil {
+			return err
+		}
+	}
+
+	var upstreams []models.ProxyServiceUpstream
+	if config.Upstreams != nil {
+		var err error
+		if upstreams, err = normalizeUpstreams(id, *config.Upstreams); err != nil {
+			return err
+		}
+	}
+
+	var accessRules []models.ProxyServiceAccessRule
+	if config.AccessRules != nil {
+		var err error
+		if accessRules, err = normalizeAccessRules(id, *config.AccessRules); err != nil {
+			return err
+		}
+	}
+
+	var headerRules []models.ProxyServiceHeaderRule
+	if config.HeaderRules != nil {
+		var err error
+		if headerRules, err = normalizeHeaderRules(id, *config.HeaderRules); err != nil {
+			return err
+		}
+	}
+
+	if config.ExpiresAt != nil {
+		if config.ExpiresAt.IsZero() {
+			updates["expires_at"] = nil
+		} else {
+			updates["expires_at"] = config.ExpiresAt.UTC()
+		}
+	}
+	if config.MaxUses != nil {
+		if *config.MaxUses < 0 {
+			return invalidf("max uses cannot be negative")
+		}
+		updates["max_uses"] = *config.MaxUses
+	}
+	if config.ExpireAction != nil {
+		switch *config.ExpireAction {
+		case "", models.ExpireDisable, models.ExpireDelete:
+		default:
+			return invalidf("unsupported expire action: %s (supported: disable, delete)", *config.ExpireAction)
+		}
+		updates["expire_action"] = *config.ExpireAction
+	}
+
+	if config.ScheduleTimezone != nil {
+		timezone := strings.TrimSpace(*config.ScheduleTimezone)
+		if _, err := utils.LoadTimezone(timezone); err != nil {
+			return invalidf("invalid schedule timezone: %s", timezone)
+		}
+		updates["schedule_timezone"] = timezone
+	}
+
+	var schedules []models.ProxyServiceSchedule
+	if config.Schedules != nil {
+		var err error
+		if schedules, err = normalizeSchedules(id, *config.Schedules); err != nil {
+			return err
+		}
+	}
+
+	var errorPages []models.ProxyServiceErrorPage
+	if config.ErrorPages != nil {
+		var err error
+		if errorPages, err = normalizeErrorPages(id, *config.ErrorPages); err != nil {
+			return err
+		}
+	}
+
+	if len(updates) == 0 && config.Routes == nil && config.Upstreams == nil &&
+		config.AccessRules == nil && config.HeaderRules == nil && config.ErrorPages == nil &&
+		config.Schedules == nil {
+		return invalidf("no fields to update")
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&models.ProxyService{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		if config.Routes != nil {
+			if err := replaceChildren(tx, id, routes); err != nil {
+				return err
+			}
+		}
+		if config.Upstreams != nil {
+			if err := replaceChildren(tx, id, upstreams); err != nil {
+				return err
+			}
+		}
+		if config.AccessRules != nil {
+			if err := replaceChildren(tx, id, accessRules); err != nil {
+				return err
+			}
+		}
+		if config.HeaderRules != nil {
+			if err := replaceChildren(tx, id, headerRules); err != nil {
+				return err
+			}
+		}
+		if config.ErrorPages != nil {
+			if err := replaceChildren(tx, id, errorPages); err != nil {
+				return err
+			}
+		}
+		if config.Schedules != nil {
+			if err := replaceChildren(tx, id, schedules); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// replaceChildren replaces every row of a service in one of its child tables
+func replaceChildren[T any](tx *gorm.DB, id string, rows []T) error {
+	var model T
+	if err := tx.Where("service_id = ?", id).Delete(&model).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+// authUpdates validates the access gate settings of an update against the
+// stored service and adds them to updates, hashing any new secret
+func (r *ServiceRepository) authUpdates(id string, config models.ProxyServiceConfig, updates map[string]any) error {
+	var current models.ProxyService
+	if err := r.db.Select("auth_mode", "auth_username", "auth_secret_hash").Where("id = ?", id).First(&current).Error; err != nil {
+		return err
+	}
 
-	if len(updates) == 0 {
-		return fmt.Errorf("no fields to update")
+	mode := current.AuthMode
+	if config.AuthMode != nil {
+		switch *config.AuthMode {
+		case models.AuthModeNone, models.AuthModeBasic, models.AuthModeBearer, models.AuthModePassword:
+		default:
+			return invalidf("unsupported auth mode: %s", *config.AuthMode)
+		}
+		mode = *config.AuthMode
+		updates["auth_mode"] = mode
+	}
+
+	username := current.AuthUsername
+	if config.AuthUsername != nil {
+		username = strings.TrimSpace(*config.AuthUsername)
+		if strings.Contains(username, ":") {
+			return invalidf("auth username cannot contain ':'")
+		}
+		updates["auth_username"] = username
 	}
 
-	if err := r.db.Model(&models.ProxyService{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+	hasSecret := current.AuthSecretHash != ""
+	if config.AuthSecret != nil {
+		if *config.AuthSecret == "" {
+			updates["auth_secret_hash"] = ""
+			hasSecret = false
+		} else {
+			updates["auth_secret_hash"] = utils.HashPassword(*config.AuthSecret)
+			hasSecret = true
+		}
+	}
+
+	if mode != models.AuthModeNone && !hasSecret {
+		return invalidf("auth mode %s requires a secret", mode)
+	}
+	if mode == models.AuthModeBasic && username == "" {
+		return invalidf("basic auth requires a username")
+	}
+
+	return nil
+}
+
+// upstreamTLSUpdates validates the upstream TLS settings of an update against
+// the stored service and adds them to updates
+func (r *ServiceRepository) upstreamTLSUpdates(id string, config models.ProxyServiceConfig, updates map[string]any) error {
+	var current models.ProxyService
+	if err := r.db.Select("upstream_tls", "upstream_ca", "upstream_fingerprint").Where("id = ?", id).First(&current).Error; err != nil {
 		return err
 	}
 
+	mode := current.UpstreamTLS
+	if config.UpstreamTLS != nil {
+		switch *config.UpstreamTLS {
+		case models.UpstreamTLSNone, models.UpstreamTLSSystem, models.UpstreamTLSCustomCA,
+			models.UpstreamTLSFingerprint, models.UpstreamTLSInsecure:
+		default:
+			return invalidf("unsupported upstream TLS mode: %s", *config.UpstreamTLS)
+		}
+		mode = *config.UpstreamTLS
+		updates["upstream_tls"] = mode
+	}
+
+	ca := current.UpstreamCA
+	if config.UpstreamCA != nil {
+		ca = *config.UpstreamCA
+		if ca != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(ca)) {
+			return invalidf("upstream CA must be a PEM encoded certificate")
+		}
+		updates["upstream_ca"] = ca
+	}
+
+	fingerprint := current.UpstreamFingerprint
+	if config.UpstreamFingerprint != nil {
+		fingerprint = utils.NormalizeFingerprint(*config.UpstreamFingerprint)
+		if fingerprint != "" {
+			if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != 32 {
+				return invalidf("upstream fingerprint must be a hex encoded SHA-256 digest")
+			}
+		}
+		updates["upstream_fingerprint"] = fingerprint
+	}
+
+	if mode == models.UpstreamTLSCustomCA && ca == "" {
+		return invalidf("upstream TLS mode %s requires an upstream CA", mode)
+	}
+	if mode == models.UpstreamTLSFingerprint && fingerprint == "" {
+		return invalidf("upstream TLS mode %s requires an upstream fingerprint", mode)
+	}
 	return nil
 }
 
-// DeleteService deletes a proxy service
-func (r *ServiceRepository) DeleteService(id string) error {
-	if err := r.db.Where("id = ?", id).Delete(&models.ProxyService{}).Error; err != nil {
+// shadowUpdates validates the shadow upstream settings of an update against
+// the stored service and adds them to updates
+func (r *ServiceRepository) shadowUpdates(id string, config models.ProxyServiceConfig, updates map[string]any) error {
+	var current models.ProxyService
+	if err := r.db.Select("shadow_host", "shadow_port", "shadow_percent").Where("id = ?", id).First(&current).Error; err != nil {
 		return err
 	}
+
+	host := current.ShadowHost
+	if config.ShadowHost != nil {
+		host = strings.TrimSpace(*config.ShadowHost)
+		updates["shadow_host"] = host
+	}
+
+	port := current.ShadowPort
+	if config.ShadowPort != nil {
+		if *config.ShadowPort < 0 || *config.ShadowPort > 65535 {
+			return invalidf("invalid shadow port: %d", *config.ShadowPort)
+		}
+		port = *config.ShadowPort
+		updates["shadow_port"] = port
+	}
+
+	percent := current.ShadowPercent
+	if config.ShadowPercent != nil {
+		if *config.ShadowPercent < 0 || *config.ShadowPercent > 100 {
+			return invalidf("shadow percent must be between 0 and 100")
+		}
+		percent = *config.ShadowPercent
+		updates["shadow_percent"] = percent
+	}
+
+	if percent > 0 && (host == "" || port == 0) {
+		return invalidf("shadow percent requires a shadow host and port")
+	}
 	return nil
 }
 
+// normalizeRoutes validates path routes and returns them cleaned up and ordered
+func normalizeRoutes(serviceID string, routes []models.ProxyServiceRoute) ([]models.ProxyServiceRoute, error) {
+	seen := make(map[string]bool)
+	normalized := make([]models.ProxyServiceRoute, 0, len(routes))
+
+	for i, route := range routes {
+		prefix := "/" + strings.Trim(strings.TrimSpace(route.PathPrefix), "/")
+		if prefix == "/" {
+			return nil, invalidf("route path prefix cannot be empty or \"/\"")
+		}
+		if seen[prefix] {
+			return nil, invalidf("duplicate route path prefix: %s", prefix)
+		}
+		seen[prefix] = true
+
+		if route.LocalHost == "" {
+			return nil, invalidf("route %s: local host cannot be empty", prefix)
+		}
+		if route.LocalPort < 1 || route.LocalPort > 65535 {
+			return nil, invalidf("route %s: invalid local port: %d", prefix, route.LocalPort)
+		}
+
+		normalized = append(normalized, models.ProxyServiceRoute{
+			ServiceID:   serviceID,
+			PathPrefix:  prefix,
+			LocalHost:   route.LocalHost,
+			LocalPort:   route.LocalPort,
+			StripPrefix: route.StripPrefix,
+			Position:    i,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeUpstreams validates upstream targets and returns them ordered, with
+// the default weight applied
+func normalizeUpstreams(serviceID string, upstreams []models.ProxyServiceUpstream) ([]models.ProxyServiceUpstream, error) {
+	seen := make(map[string]bool)
+	normalized := make([]models.ProxyServiceUpstream, 0, len(upstreams))
+
+	for i, upstream := range upstreams {
+		host := strings.TrimSpace(upstream.Host)
+		if host == "" {
+			return nil, invalidf("upstream host cannot be empty")
+		}
+		if upstream.Port < 1 || upstream.Port > 65535 {
+			return nil, invalidf("upstream %s: invalid port: %d", host, upstream.Port)
+		}
+
+		target := fmt.Sprintf("%s:%d", host, upstream.Port)
+		if seen[target] {
+			return nil, invalidf("duplicate upstream: %s", target)
+		}
+		seen[target] = true
+
+		weight := upstream.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 1 || weight > 100 {
+			return nil, invalidf("upstream %s: weight must be between 1 and 100", target)
+		}
+
+		normalized = append(normalized, models.ProxyServiceUpstream{
+			ServiceID: serviceID,
+			Host:      host,
+			Port:      upstream.Port,
+			Weight:    weight,
+			Position:  i,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeAccessRules validates access rules and returns them ordered, with
+// bare addresses turned into single-host CIDRs
+func normalizeAccessRules(serviceID string, rules []models.ProxyServiceAccessRule) ([]models.ProxyServiceAccessRule, error) {
+	normalized := make([]models.ProxyServiceAccessRule, 0, len(rules))
+
+	for i, rule := range rules {
+		switch rule.Action {
+		case models.AccessAllow, models.AccessDeny:
+		default:
+			return nil, invalidf("unsupported access rule action: %s", rule.Action)
+		}
+
+		cidr := strings.TrimSpace(rule.CIDR)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, invalidf("invalid access rule address: %s", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, invalidf("invalid access rule CIDR: %s", cidr)
+		}
+
+		normalized = append(normalized, models.ProxyServiceAccessRule{
+			ServiceID: serviceID,
+			Action:    rule.Action,
+			CIDR:      ipNet.String(),
+			Position:  i,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeHeaderRules validates header rules and returns them ordered
+func normalizeHeaderRules(serviceID string, rules []models.ProxyServiceHeaderRule) ([]models.ProxyServiceHeaderRule, error) {
+	normalized := make([]models.ProxyServiceHeaderRule, 0, len(rules))
+
+	for i, rule := range rules {
+		switch rule.Direction {
+		case models.HeaderRequest, models.HeaderResponse:
+		default:
+			return nil, invalidf("unsupported header rule direction: %s", rule.Direction)
+		}
+		switch rule.Action {
+		case models.HeaderAdd, models.HeaderSet, models.HeaderRemove:
+		default:
+			return nil, invalidf("unsupported header rule action: %s", rule.Action)
+		}
+
+		name := strings.TrimSpace(rule.Name)
+		if !utils.IsValidHeaderName(name) {
+			return nil, invalidf("invalid header name: %q", name)
+		}
+		value := rule.Value
+		if rule.Action == models.HeaderRemove {
+			value = ""
+		} else if strings.ContainsAny(value, "\r\n") {
+			return nil, invalidf("header %s: value cannot contain line breaks", name)
+		}
+
+		normalized = append(normalized, models.ProxyServiceHeaderRule{
+			ServiceID: serviceID,
+			Direction: rule.Direction,
+			Action:    rule.Action,
+			Name:      name,
+			Value:     value,
+			Position:  i,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeErrorPages validates custom error pages, allowing one template per
+// status
+func normalizeErrorPages(serviceID string, pages []models.ProxyServiceErrorPage) ([]models.ProxyServiceErrorPage, error) {
+	seen := make(map[int]bool)
+	normalized := make([]models.ProxyServiceErrorPage, 0, len(pages))
+
+	for _, page := range pages {
+		switch page.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return nil, invalidf("unsupported error page status: %d (supported: 502, 503, 504)", page.Status)
+		}
+		if seen[page.Status] {
+			return nil, invalidf("duplicate error page for status %d", page.Status)
+		}
+		seen[page.Status] = true
+
+		if strings.TrimSpace(page.Template) == "" {
+			return nil, invalidf("error page %d: template cannot be empty", page.Status)
+		}
+		if len(page.Template) > models.MaxErrorPageSize {
+			return nil, invalidf("error page %d: template exceeds %d bytes", page.Status, models.MaxErrorPageSize)
+		}
+		if _, err := template.New("error").Parse(page.Template); err != nil {
+			return nil, invalidf("error page %d: %w", page.Status, err)
+		}
+
+		normalized = append(normalized, models.ProxyServiceErrorPage{
+			ServiceID: serviceID,
+			Status:    page.Status,
+			Template:  page.Template,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeSchedules validates availability windows and returns them ordered,
+// with their weekdays and times cleaned up
+func normalizeSchedules(serviceID string, schedules []models.ProxyServiceSchedule) ([]models.ProxyServiceSchedule, error) {
+	normalized := make([]models.ProxyServiceSchedule, 0, len(schedules))
+
+	for i, schedule := range schedules {
+		days := strings.ToLower(strings.ReplaceAll(schedule.Days, " ", ""))
+		if days == "" {
+			days = "*"
+		}
+		if _, err := utils.ParseWeekdays(days); err != nil {
+			return nil, invalidf("schedule %d: %w", i+1, err)
+		}
+
+		start, err := utils.ParseClock(schedule.Start)
+		if err != nil {
+			return nil, invalidf("schedule %d: %w", i+1, err)
+		}
+		end, err := utils.ParseClock(schedule.End)
+		if err != nil {
+			return nil, invalidf("schedule %d: %w", i+1, err)
+		}
+		if start == 24*60 {
+			return nil, invalidf("schedule %d: start must be before 24:00", i+1)
+		}
+		if start == end {
+			return nil, invalidf("schedule %d: start and end cannot be the same", i+1)
+		}
+
+		normalized = append(normalized, models.ProxyServiceSchedule{
+			ServiceID: serviceID,
+			Days:      days,
+			Start:     fmt.Sprintf("%02d:%02d", start/60, start%60),
+			End:       fmt.Sprintf("%02d:%02d", end/60, end%60),
+			Position:  i,
+		})
+	}
+
+	return normalized, nil
+}
+
+// normalizeMIMETypes validates a comma-separated list of MIME types, returning
+// it lowercased without blanks
+func normalizeMIMETypes(list string) (string, error) {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		major, minor, ok := strings.Cut(t, "/")
+		if !ok || major == "" || minor == "" || strings.ContainsAny(t, " ;") {
+			return "", invalidf("invalid MIME type: %s", t)
+		}
+		types = append(types, t)
+	}
+	return strings.Join(types, ","), nil
+}
+
+// DeleteService deletes a proxy service
+func (r *ServiceRepository) DeleteService(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range serviceChildTables {
+			if err := tx.Where("service_id = ?", id).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id = ?", id).Delete(&models.ProxyService{}).Error
+	})
+}
+
 // GetServices returns all proxy services
 func (r *ServiceRepository) GetServices() ([]*models.ProxyService, error) {
 	var services []*models.ProxyService
-	if err := r.db.Order("name").Find(&services).Error; err != nil {
+	if err := r.withChildren().Order("name").Find(&services).Error; err != nil {
 		return nil, err
 	}
 	return services, nil
@@ -110,12 +881,32 @@ func (r *ServiceRepository) GetServices() ([]*models.ProxyService, error) {
 // GetService returns a single proxy service by ID
 func (r *ServiceRepository) GetService(id string) (*models.ProxyService, error) {
 	var service models.ProxyService
-	if err := r.db.Where("id = ?", id).First(&service).Error; err != nil {
+	if err := r.withChildren().Where("id = ?", id).First(&service).Error; err != nil {
 		return nil, err
 	}
 	return &service, nil
 }
 
+// withChildren preloads the child tables of proxy services
+func (r *ServiceRepository) withChildren() *gorm.DB {
+	byPosition := func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}
+	return r.db.Preload("Routes", byPosition).
+		Preload("Upstreams", byPosition).
+		Preload("AccessRules", byPosition).
+		Preload("HeaderRules", byPosition).
+		Preload("Schedules", byPosition).
+		Preload("ErrorPages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("status")
+		})
+}
+
+// SetServiceUses stores the number of times a temporary service has been used
+func (r *ServiceRepository) SetServiceUses(id string, uses int) error {
+	return r.db.Model(&models.ProxyService{}).Where("id = ?", id).UpdateColumn("uses", uses).Error
+}
+
 // GetServiceByHostPort finds a service by host and port
 func (r *ServiceRepository) GetServiceByHostPort(host string, port int) (*models.ProxyService, error) {
 	var service models.ProxyService
@@ -125,6 +916,15 @@ func (r *ServiceRepository) GetServiceByHostPort(host string, port int) (*models
 	return &service, nil
 }
 
+// GetServiceByTunnelPort finds the service listening on a tunnel port
+func (r *ServiceRepository) GetServiceByTunnelPort(port int) (*models.ProxyService, error) {
+	var service models.ProxyService
+	if err := r.db.Where("tunnel_port = ?", port).First(&service).Error; err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
 // GetUsedPorts returns a list of all used tunnel ports
 func (r *ServiceRepository) GetUsedPorts() ([]int, error) {
 	var usedPorts []int
@@ -144,5 +944,12 @@ func (r *ServiceRepository) Count() (int, error) {
 
 // Clear removes all proxy services
 func (r *ServiceRepository) Clear() error {
-	return r.db.Delete(&models.ProxyService{}, "1=1").Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range serviceChildTables {
+			if err := tx.Where("1=1").Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.ProxyService{}, "1=1").Error
+	})
 }
@@ -0,0 +1,372 @@
+package repositories
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/tphan267/arqut-edge-ce/pkg/models"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestRepository returns a service repository on a fresh database
+func newTestRepository(t *testing.T) *ServiceRepository {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServiceRepository(db)
+}
+
+// addTestService stores a service with the given protocol
+func addTestService(t *testing.T, r *ServiceRepository, protocol string) *models.ProxyService {
+	t.Helper()
+
+	service, err := r.AddService("test", "127.0.0.1", 8080, 8000, protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// updateCase is one update of a service and the error it should fail with,
+// or "" if it should succeed
+type updateCase struct {
+	name    string
+	config  models.ProxyServiceConfig
+	wantErr string
+}
+
+// runUpdateCases applies the updates in order to one service, so later cases
+// see the settings stored by earlier ones
+func runUpdateCases(t *testing.T, protocol string, cases []updateCase) {
+	t.Helper()
+
+	r := newTestRepository(t)
+	service := addTestService(t, r, protocol)
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.UpdateService(service.ID, tt.config)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("UpdateService: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("UpdateService succeeded, want error %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("UpdateService error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testCertificatePEM returns a self-signed certificate in PEM form
+func testCertificatePEM(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestUpdateServiceUpstreamTLS(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+
+	runUpdateCases(t, models.ProtocolHTTP, []updateCase{
+		{name: "unknown mode", config: models.ProxyServiceConfig{UpstreamTLS: ptr("strict")}, wantErr: "unsupported upstream TLS mode"},
+		{name: "custom CA without CA", config: models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSCustomCA)}, wantErr: "requires an upstream CA"},
+		{name: "fingerprint without fingerprint", config: models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSFingerprint)}, wantErr: "requires an upstream fingerprint"},
+		{name: "invalid CA", config: models.ProxyServiceConfig{UpstreamCA: ptr("not a certificate")}, wantErr: "PEM encoded certificate"},
+		{name: "short fingerprint", config: models.ProxyServiceConfig{UpstreamFingerprint: ptr("abcd")}, wantErr: "SHA-256 digest"},
+		{name: "non-hex fingerprint", config: models.ProxyServiceConfig{UpstreamFingerprint: ptr(strings.Repeat("zz", 32))}, wantErr: "SHA-256 digest"},
+		{name: "system", config: models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSSystem)}},
+		{name: "custom CA with CA", config: models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSCustomCA), UpstreamCA: ptr(testCertificatePEM(t))}},
+		{name: "clearing the CA of custom CA", config: models.ProxyServiceConfig{UpstreamCA: ptr("")}, wantErr: "requires an upstream CA"},
+		{name: "colon separated fingerprint", config: models.ProxyServiceConfig{UpstreamFingerprint: ptr(strings.Repeat("AB:", 31) + "AB")}},
+		{name: "fingerprint mode with stored fingerprint", config: models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSFingerprint)}},
+		{name: "clearing the fingerprint of fingerprint mode", config: models.ProxyServiceConfig{UpstreamFingerprint: ptr("")}, wantErr: "requires an upstream fingerprint"},
+		{name: "fingerprint replaced", config: models.ProxyServiceConfig{UpstreamFingerprint: ptr(fingerprint)}},
+	})
+}
+
+func TestUpdateServiceAuth(t *testing.T) {
+	runUpdateCases(t, models.ProtocolHTTP, []updateCase{
+		{name: "unknown mode", config: models.ProxyServiceConfig{AuthMode: ptr("oauth")}, wantErr: "unsupported auth mode"},
+		{name: "bearer without secret", config: models.ProxyServiceConfig{AuthMode: ptr(models.AuthModeBearer)}, wantErr: "requires a secret"},
+		{name: "basic without username", config: models.ProxyServiceConfig{AuthMode: ptr(models.AuthModeBasic), AuthSecret: ptr("s3cret")}, wantErr: "requires a username"},
+		{name: "username with colon", config: models.ProxyServiceConfig{AuthUsername: ptr("ad:min")}, wantErr: "cannot contain ':'"},
+		{name: "basic", config: models.ProxyServiceConfig{AuthMode: ptr(models.AuthModeBasic), AuthUsername: ptr(" admin "), AuthSecret: ptr("s3cret")}},
+		{name: "clearing the username of basic", config: models.ProxyServiceConfig{AuthUsername: ptr("")}, wantErr: "requires a username"},
+		{name: "switching mode keeps the secret", config: models.ProxyServiceConfig{AuthMode: ptr(models.AuthModePassword)}},
+		{name: "clearing the secret", config: models.ProxyServiceConfig{AuthSecret: ptr("")}, wantErr: "requires a secret"},
+		{name: "turning the gate off", config: models.ProxyServiceConfig{AuthMode: ptr(models.AuthModeNone), AuthSecret: ptr("")}},
+	})
+}
+
+func TestUpdateServiceHostname(t *testing.T) {
+	r := newTestRepository(t)
+	other := addTestService(t, r, models.ProtocolHTTP)
+	if err := r.UpdateService(other.ID, models.ProxyServiceConfig{Hostname: ptr("taken.example")}); err != nil {
+		t.Fatal(err)
+	}
+	service := addTestService(t, r, models.ProtocolHTTP)
+
+	tests := []struct {
+		hostname string
+		want     string // stored hostname
+		wantErr  string
+	}{
+		{hostname: "App.Example.", want: "app.example"},
+		{hostname: "app.example", want: "app.example"},
+		{hostname: "taken.example", wantErr: "already used by another service"},
+		{hostname: "TAKEN.example", wantErr: "already used by another service"},
+		{hostname: "bad_host!", wantErr: "invalid hostname"},
+		{hostname: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			err := r.UpdateService(service.ID, models.ProxyServiceConfig{Hostname: &tt.hostname})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UpdateService error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateService: %v", err)
+			}
+
+			stored, err := r.GetService(service.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Hostname != tt.want {
+				t.Fatalf("hostname = %q, want %q", stored.Hostname, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	r := newTestRepository(t)
+	service := addTestService(t, r, models.ProtocolHTTP)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "invalid protocol", err: func() error { _, err := r.AddService("x", "127.0.0.1", 80, 8001, "ftp"); return err }(), want: true},
+		{name: "invalid port", err: r.UpdateService(service.ID, models.ProxyServiceConfig{LocalPort: ptr(0)}), want: true},
+		{name: "empty update", err: r.UpdateService(service.ID, models.ProxyServiceConfig{}), want: true},
+		{name: "unknown service", err: r.UpdateService("missing", models.ProxyServiceConfig{UpstreamTLS: ptr(models.UpstreamTLSSystem)}), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("no error")
+			}
+			var invalid *ValidationError
+			if got := errors.As(tt.err, &invalid); got != tt.want {
+				t.Fatalf("ValidationError = %v, want %v (%v)", got, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceHealthCheck(t *testing.T) {
+	runUpdateCases(t, models.ProtocolHTTP, []updateCase{
+		{name: "unknown type", config: models.ProxyServiceConfig{HealthCheck: ptr("icmp")}, wantErr: "unsupported health check type"},
+		{name: "relative path", config: models.ProxyServiceConfig{HealthCheckPath: ptr("healthz")}, wantErr: "must start with /"},
+		{name: "negative interval", config: models.ProxyServiceConfig{HealthCheckInterval: ptr(-1)}, wantErr: "between 0 (default) and 3600"},
+		{name: "long timeout", config: models.ProxyServiceConfig{HealthCheckTimeout: ptr(61)}, wantErr: "between 0 (default) and 60"},
+		{name: "invalid status", config: models.ProxyServiceConfig{HealthCheckStatus: ptr(600)}, wantErr: "invalid health check status"},
+		{name: "healthy threshold", config: models.ProxyServiceConfig{HealthyThreshold: ptr(11)}, wantErr: "healthy threshold"},
+		{name: "unhealthy threshold", config: models.ProxyServiceConfig{UnhealthyThreshold: ptr(-1)}, wantErr: "unhealthy threshold"},
+		{name: "http check", config: models.ProxyServiceConfig{
+			HealthCheck:         ptr(models.HealthCheckHTTP),
+			HealthCheckPath:     ptr("/healthz"),
+			HealthCheckInterval: ptr(30),
+			HealthCheckTimeout:  ptr(0),
+			HealthCheckStatus:   ptr(204),
+			HealthyThreshold:    ptr(10),
+			UnhealthyThreshold:  ptr(0),
+		}},
+	})
+}
+
+func TestNormalizeAccessRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []models.ProxyServiceAccessRule
+		want    []string // normalized CIDRs
+		wantErr string
+	}{
+		{
+			name:  "CIDRs are canonical",
+			rules: []models.ProxyServiceAccessRule{{Action: models.AccessAllow, CIDR: " 10.1.2.3/8 "}},
+			want:  []string{"10.0.0.0/8"},
+		},
+		{
+			name: "addresses become host routes",
+			rules: []models.ProxyServiceAccessRule{
+				{Action: models.AccessDeny, CIDR: "192.168.1.5"},
+				{Action: models.AccessAllow, CIDR: "fd00::1"},
+			},
+			want: []string{"192.168.1.5/32", "fd00::1/128"},
+		},
+		{name: "no rules", want: []string{}},
+		{name: "unknown action", rules: []models.ProxyServiceAccessRule{{Action: "block", CIDR: "10.0.0.0/8"}}, wantErr: "unsupported access rule action"},
+		{name: "invalid address", rules: []models.ProxyServiceAccessRule{{Action: models.AccessDeny, CIDR: "10.0.0"}}, wantErr: "invalid access rule address"},
+		{name: "invalid CIDR", rules: []models.ProxyServiceAccessRule{{Action: models.AccessDeny, CIDR: "10.0.0.0/33"}}, wantErr: "invalid access rule CIDR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := normalizeAccessRules("svc", tt.rules)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, rule := range rules {
+				if rule.CIDR != tt.want[i] || rule.Position != i || rule.ServiceID != "svc" || rule.Action != tt.rules[i].Action {
+					t.Fatalf("rule %d = %+v, want CIDR %s at position %d", i, rule, tt.want[i], i)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeRoutes(t *testing.T) {
+	route := func(prefix string) models.ProxyServiceRoute {
+		return models.ProxyServiceRoute{PathPrefix: prefix, LocalHost: "127.0.0.1", LocalPort: 9000}
+	}
+
+	tests := []struct {
+		name    string
+		routes  []models.ProxyServiceRoute
+		want    []string // normalized prefixes
+		wantErr string
+	}{
+		{name: "prefixes are cleaned up", routes: []models.ProxyServiceRoute{route(" api/ "), route("/static//")}, want: []string{"/api", "/static"}},
+		{name: "nested prefixes", routes: []models.ProxyServiceRoute{route("/api"), route("/api/v2")}, want: []string{"/api", "/api/v2"}},
+		{name: "no routes", want: []string{}},
+		{name: "root prefix", routes: []models.ProxyServiceRoute{route("/")}, wantErr: "cannot be empty"},
+		{name: "empty prefix", routes: []models.ProxyServiceRoute{route("  ")}, wantErr: "cannot be empty"},
+		{name: "duplicate after cleanup", routes: []models.ProxyServiceRoute{route("/api"), route("api/")}, wantErr: "duplicate route path prefix: /api"},
+		{name: "no host", routes: []models.ProxyServiceRoute{{PathPrefix: "/api", LocalPort: 9000}}, wantErr: "local host cannot be empty"},
+		{name: "invalid port", routes: []models.ProxyServiceRoute{{PathPrefix: "/api", LocalHost: "127.0.0.1", LocalPort: 70000}}, wantErr: "invalid local port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := normalizeRoutes("svc", tt.routes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(routes) != len(tt.want) {
+				t.Fatalf("got %d routes, want %d", len(routes), len(tt.want))
+			}
+			for i, route := range routes {
+				if route.PathPrefix != tt.want[i] || route.Position != i || route.ServiceID != "svc" {
+					t.Fatalf("route %d = %+v, want prefix %s at position %d", i, route, tt.want[i], i)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeHeaderRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.ProxyServiceHeaderRule
+		want    models.ProxyServiceHeaderRule
+		wantErr string
+	}{
+		{
+			name: "name is trimmed",
+			rule: models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: models.HeaderSet, Name: " X-Env ", Value: "prod"},
+			want: models.ProxyServiceHeaderRule{ServiceID: "svc", Direction: models.HeaderRequest, Action: models.HeaderSet, Name: "X-Env", Value: "prod"},
+		},
+		{
+			name: "remove drops the value",
+			rule: models.ProxyServiceHeaderRule{Direction: models.HeaderResponse, Action: models.HeaderRemove, Name: "Server", Value: "ignored"},
+			want: models.ProxyServiceHeaderRule{ServiceID: "svc", Direction: models.HeaderResponse, Action: models.HeaderRemove, Name: "Server"},
+		},
+		{name: "unknown direction", rule: models.ProxyServiceHeaderRule{Direction: "both", Action: models.HeaderSet, Name: "X-A"}, wantErr: "unsupported header rule direction"},
+		{name: "unknown action", rule: models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: "append", Name: "X-A"}, wantErr: "unsupported header rule action"},
+		{name: "invalid name", rule: models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: models.HeaderSet, Name: "X A"}, wantErr: "invalid header name"},
+		{name: "empty name", rule: models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: models.HeaderSet}, wantErr: "invalid header name"},
+		{name: "line break", rule: models.ProxyServiceHeaderRule{Direction: models.HeaderRequest, Action: models.HeaderAdd, Name: "X-A", Value: "a\r\nX-B: b"}, wantErr: "line breaks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := normalizeHeaderRules("svc", []models.ProxyServiceHeaderRule{tt.rule})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 1 || rules[0] != tt.want {
+				t.Fatalf("rules = %+v, want %+v", rules, tt.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,8 @@ type SQLiteStorage struct {
 	logger *logger.Logger
 
 	serviceRepo *repositories.ServiceRepository
+	certRepo    *repositories.CertificateRepository
+	accessLog   *repositories.AccessLogRepository
 }
 
 // NewSQLiteStorage creates a new SQLite storage instance
@@ -40,6 +42,8 @@ func NewSQLiteStorage(dbPath string, appLogger *logger.Logger) (Storage, error)
 		db:          db,
 		logger:      appLogger,
 		serviceRepo: repositories.NewServiceRepository(db),
+		certRepo:    repositories.NewCertificateRepository(db),
+		accessLog:   repositories.NewAccessLogRepository(db),
 	}, nil
 }
 
@@ -53,6 +57,16 @@ func (s *SQLiteStorage) ServiceRepo() *repositories.ServiceRepository {
 	return s.serviceRepo
 }
 
+// CertRepo returns the certificate repository
+func (s *SQLiteStorage) CertRepo() *repositories.CertificateRepository {
+	return s.certRepo
+}
+
+// AccessLogRepo returns the access log repository
+func (s *SQLiteStorage) AccessLogRepo() *repositories.AccessLogRepository {
+	return s.accessLog
+}
+
 // Close closes the database connection
 func (s *SQLiteStorage) Close() error {
 	sqlDB, err := s.db.DB()
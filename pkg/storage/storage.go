@@ -9,5 +9,7 @@ import (
 type Storage interface {
 	DB() *gorm.DB
 	ServiceRepo() *repositories.ServiceRepository
+	CertRepo() *repositories.CertificateRepository
+	AccessLogRepo() *repositories.AccessLogRepository
 	Close() error
 }
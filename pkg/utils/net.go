@@ -3,8 +3,29 @@ package utils
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
+// IsValidHostname reports whether host is a syntactically valid DNS name
+func IsValidHostname(host string) bool {
+	if len(host) == 0 || len(host) > 253 || net.ParseIP(host) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func GetLocalIPs(onlyIPv4 bool) ([]string, error) {
 	var ips []string
 
@@ -76,3 +97,43 @@ func GetLocalSubnets() ([]string, error) {
 	}
 	return subnets, nil
 }
+
+// ParsePortList parses a comma-separated list of ports and port ranges such
+// as "8080,8443,8500-8510"
+func ParsePortList(spec string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(to); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid port range: %s", part)
+			}
+		}
+		for port := first; port <= last; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// parsePort parses a single TCP or UDP port number
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %s", strings.TrimSpace(s))
+	}
+	return port, nil
+}
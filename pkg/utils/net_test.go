@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePortList(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{spec: ""},
+		{spec: "8080", want: []int{8080}},
+		{spec: " 8080 , 8443 ,", want: []int{8080, 8443}},
+		{spec: "8500-8503", want: []int{8500, 8501, 8502, 8503}},
+		{spec: "22,8500-8501", want: []int{22, 8500, 8501}},
+		{spec: "9000-9000", want: []int{9000}},
+		{spec: "8510-8500", wantErr: true},
+		{spec: "0", wantErr: true},
+		{spec: "65536", wantErr: true},
+		{spec: "80-", wantErr: true},
+		{spec: "http", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			ports, err := ParsePortList(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortList(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(ports, tt.want) {
+				t.Fatalf("ParsePortList(%q) = %v, want %v", tt.spec, ports, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // edge devices often ship without a zoneinfo database
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWeekdays parses a cron-like list of weekdays such as "mon-fri",
+// "sat,sun" or "*", indexed by time.Weekday. Ranges may wrap around the end of
+// the week ("fri-mon"). An empty spec means every day.
+func ParseWeekdays(spec string) ([7]bool, error) {
+	var days [7]bool
+
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, ok := weekdayNames[strings.TrimSpace(from)]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday: %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[strings.TrimSpace(to)]; !ok {
+				return days, fmt.Errorf("invalid weekday: %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// ParseClock parses a "HH:MM" time of day into minutes after midnight.
+// "24:00" is accepted as the end of the day.
+func ParseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || len(mm) != 2 {
+		return 0, fmt.Errorf("invalid time of day: %q (expected HH:MM)", s)
+	}
+	hours, err := strconv.Atoi(hh)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q (expected HH:MM)", s)
+	}
+	minutes, err := strconv.Atoi(mm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q (expected HH:MM)", s)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time of day: %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// LoadTimezone returns the location of an IANA timezone name, or the local
+// timezone of the device for an empty name
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []time.Weekday
+		wantErr bool
+	}{
+		{spec: "", want: []time.Weekday{0, 1, 2, 3, 4, 5, 6}},
+		{spec: "*", want: []time.Weekday{0, 1, 2, 3, 4, 5, 6}},
+		{spec: "mon-fri", want: []time.Weekday{1, 2, 3, 4, 5}},
+		{spec: "Sat, sun", want: []time.Weekday{0, 6}},
+		{spec: "fri-mon", want: []time.Weekday{0, 1, 5, 6}},
+		{spec: "wed", want: []time.Weekday{3}},
+		{spec: "mon-xyz", wantErr: true},
+		{spec: "weekdays", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			days, err := ParseWeekdays(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWeekdays(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var want [7]bool
+			for _, day := range tt.want {
+				want[day] = true
+			}
+			if days != want {
+				t.Fatalf("ParseWeekdays(%q) = %v, want %v", tt.spec, days, want)
+			}
+		})
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "00:00", want: 0},
+		{value: "09:30", want: 570},
+		{value: " 7:05 ", want: 425},
+		{value: "24:00", want: 1440},
+		{value: "24:01", wantErr: true},
+		{value: "12:60", wantErr: true},
+		{value: "12:5", wantErr: true},
+		{value: "noon", wantErr: true},
+		{value: "-1:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseClock(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClock(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("ParseClock(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
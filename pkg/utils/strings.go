@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/big"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -66,3 +67,28 @@ func ToString(data any) string {
 func HashKey(key string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(key)))
 }
+
+// NormalizeFingerprint lowercases a hex certificate fingerprint and strips the
+// colon or space separators commonly used when displaying it
+func NormalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.ReplaceAll(fingerprint, ":", "")
+	fingerprint = strings.ReplaceAll(fingerprint, " ", "")
+	return strings.ToLower(fingerprint)
+}
+
+// IsValidHeaderName reports whether name is a valid HTTP header field name
+// (an RFC 7230 token)
+func IsValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
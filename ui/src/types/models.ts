@@ -5,11 +5,177 @@ export interface ProxyService {
   name: string;
   local_host: string;
   local_port: number;
-  protocol: 'http' | 'ws';
+  protocol: 'http' | 'ws' | 'tcp' | 'udp';
   enabled: boolean;
+  hostname?: string;
   created_at?: string;
   updated_at?: string;
   public_port?: number;
+  stats?: ProxyServiceStats;
+  upstream_tls?: '' | 'system' | 'custom_ca' | 'fingerprint' | 'insecure';
+  upstream_ca?: string;
+  upstream_fingerprint?: string;
+  upstream_sni?: string;
+  tls_enabled?: boolean;
+  routes?: ProxyServiceRoute[];
+  upstreams?: ProxyServiceUpstream[];
+  load_balancing?: '' | 'round_robin' | 'least_conn' | 'weighted';
+  health_check?: '' | 'http' | 'tcp';
+  health_check_path?: string;
+  health_check_interval?: number;
+  health_check_timeout?: number;
+  health_check_status?: number;
+  healthy_threshold?: number;
+  unhealthy_threshold?: number;
+  health?: ProxyServiceHealth;
+  auth_mode?: '' | 'basic' | 'bearer' | 'password';
+  auth_username?: string;
+  auth_secret?: string;
+  access_rules?: ProxyServiceAccessRule[];
+  header_rules?: ProxyServiceHeaderRule[];
+  timeout_profile?: '' | 'standard' | 'streaming';
+  read_header_timeout?: number;
+  idle_timeout?: number;
+  write_timeout?: number;
+  upstream_header_timeout?: number;
+  flush_interval?: number;
+  rate_limit?: number;
+  rate_burst?: number;
+  conn_limit?: number;
+  breaker_threshold?: number;
+  breaker_cooldown?: number;
+  retry_attempts?: number;
+  breaker?: ProxyServiceBreaker;
+  shadow_host?: string;
+  shadow_port?: number;
+  shadow_percent?: number;
+  capture_enabled?: boolean;
+  capture_size?: number;
+  compression?: boolean;
+  compression_types?: string;
+  compression_min_size?: number;
+  cache_enabled?: boolean;
+  cache_size_mb?: number;
+  maintenance?: boolean;
+  maintenance_retry_after?: number;
+  error_pages?: ProxyServiceErrorPage[];
+  schedules?: ProxyServiceSchedule[];
+  schedule_timezone?: string;
+  next_schedule_change?: string;
+  expires_at?: string;
+  max_uses?: number;
+  uses?: number;
+  expire_action?: '' | 'disable' | 'delete';
+}
+
+export interface ProxyServiceSchedule {
+  id?: number;
+  days: string;
+  start: string;
+  end: string;
+  position?: number;
+}
+
+export interface ProxyServiceErrorPage {
+  id?: number;
+  status: 502 | 503 | 504;
+  template: string;
+}
+
+export interface ProxyServiceHeaderRule {
+  id?: number;
+  direction: 'request' | 'response';
+  action: 'add' | 'set' | 'remove';
+  name: string;
+  value?: string;
+  position?: number;
+}
+
+export interface ProxyServiceAccessRule {
+  id?: number;
+  action: 'allow' | 'deny';
+  cidr: string;
+  position?: number;
+}
+
+export interface ProxyServiceHealth {
+  status: 'unknown' | 'healthy' | 'unhealthy';
+  last_error?: string;
+  checked_at?: string;
+}
+
+export interface ProxyServiceBreaker {
+  state: 'closed' | 'open' | 'half_open';
+  failures: number;
+  open_until?: string;
+}
+
+export interface ProxyServiceUpstream {
+  id?: number;
+  host: string;
+  port: number;
+  weight: number;
+  position?: number;
+}
+
+export interface ProxyServiceRoute {
+  id?: number;
+  path_prefix: string;
+  local_host: string;
+  local_port: number;
+  strip_prefix: boolean;
+  position?: number;
+}
+
+export interface ProxyServiceStats {
+  active_sessions: number;
+  total_sessions: number;
+  bytes_in: number;
+  bytes_out: number;
+  denied: number;
+  limited: number;
+  requests: number;
+  upstream_errors: number;
+  retries: number;
+  mirrored: number;
+}
+
+export interface CapturedBody {
+  data: string;
+  encoding?: 'base64';
+  size: number;
+  truncated?: boolean;
+  decoded?: string;
+}
+
+export interface CapturedRequest {
+  id: string;
+  time: string;
+  client_ip: string;
+  method: string;
+  host: string;
+  uri: string;
+  request_headers: Record<string, string[]>;
+  request_body: CapturedBody;
+  status: number;
+  response_headers: Record<string, string[]>;
+  response_body: CapturedBody;
+  duration_ms: number;
+  replay_of?: string;
+}
+
+export interface AccessLogEntry {
+  id: number;
+  service_id: string;
+  time: string;
+  client_ip: string;
+  method: string;
+  host: string;
+  path: string;
+  status: number;
+  bytes_in: number;
+  bytes_out: number;
+  latency_ms: number;
 }
 
 export interface User {